@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RecorderStatus 录制器状态
+type RecorderStatus struct {
+	Name           string    `json:"name"`
+	IsRecording    bool      `json:"is_recording"`
+	FFmpegPID      int       `json:"ffmpeg_pid,omitempty"`
+	RetryCount     int       `json:"retry_count"`
+	Offline        bool      `json:"offline"`
+	ProbeError     string    `json:"probe_error,omitempty"`      // 最近一次探测摄像头的错误
+	ProbeErrorType string    `json:"probe_error_type,omitempty"` // unreachable、auth_failed、stream_not_found 或 other
+	CurrentSegment string    `json:"current_segment,omitempty"`
+	NextStart      time.Time `json:"next_start"`
+	NextEnd        time.Time `json:"next_end"`
+}
+
+// Status 返回录制器当前状态
+func (r *Recorder) Status() RecorderStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := RecorderStatus{
+		Name:           r.name,
+		IsRecording:    r.isRecording,
+		RetryCount:     r.retryCount,
+		Offline:        r.offline,
+		CurrentSegment: r.currentSegment,
+		NextStart:      r.startTime,
+		NextEnd:        r.endTime,
+	}
+	if r.probeErr != nil {
+		status.ProbeError = r.probeErr.Error()
+		status.ProbeErrorType = probeErrorType(r.probeErr)
+	}
+	if r.currentCmd != nil && r.currentCmd.Process != nil {
+		select {
+		case <-r.cmdDone:
+		default:
+			status.FFmpegPID = r.currentCmd.Process.Pid
+		}
+	}
+	return status
+}
+
+// Sweep 立即将录制目录中已完成的片段加入上传队列
+func (r *Recorder) Sweep() ([]string, error) {
+	return r.enqueueCompletedSegments(!r.IsRecording())
+}
+
+// APIServer 内置的 HTTP 状态和控制接口
+type APIServer struct {
+	supervisor *Supervisor // 重新加载配置后录制器可能变化，每次请求时获取
+	queue      *UploadQueue
+	token      string // 为空时 POST 接口不需要认证
+}
+
+// NewAPIServer 创建 HTTP 接口
+func NewAPIServer(supervisor *Supervisor, queue *UploadQueue, token string) *APIServer {
+	return &APIServer{
+		supervisor: supervisor,
+		queue:      queue,
+		token:      token,
+	}
+}
+
+// isLoopbackListen 判断监听地址是否只能从本机访问
+func isLoopbackListen(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireToken 设置了 token 时检查 Authorization: Bearer <token>，用于会改变录制状态的接口
+func (s *APIServer) requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// Handler 返回注册了所有路由的 http.Handler
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", s.handleStatus)
+	mux.HandleFunc("GET /api/cameras", s.handleCameras)
+	mux.HandleFunc("GET /api/cameras/{name}", s.handleCamera)
+	mux.HandleFunc("POST /api/cameras/{name}/start", s.requireToken(s.handleStart))
+	mux.HandleFunc("POST /api/cameras/{name}/stop", s.requireToken(s.handleStop))
+	mux.HandleFunc("GET /api/uploads", s.handleUploads)
+	mux.HandleFunc("POST /api/uploads/sweep", s.requireToken(s.handleSweep))
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	return mux
+}
+
+// ListenAndServe 在指定地址启动 HTTP 服务
+func (s *APIServer) ListenAndServe(addr string) error {
+	slog.Info("HTTP API listening", "addr", addr)
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (s *APIServer) cameraStatuses() []RecorderStatus {
+	recorders := s.supervisor.Recorders()
+	statuses := make([]RecorderStatus, 0, len(recorders))
+	for _, recorder := range recorders {
+		statuses = append(statuses, recorder.Status())
+	}
+	return statuses
+}
+
+func (s *APIServer) recorder(w http.ResponseWriter, r *http.Request) *Recorder {
+	recorder, ok := s.supervisor.Recorder(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "camera not found")
+		return nil
+	}
+	return recorder
+}
+
+func (s *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cameras": s.cameraStatuses(),
+		"uploads": s.queue.Stats(),
+	})
+}
+
+func (s *APIServer) handleCameras(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.cameraStatuses())
+}
+
+func (s *APIServer) handleCamera(w http.ResponseWriter, r *http.Request) {
+	if recorder := s.recorder(w, r); recorder != nil {
+		writeJSON(w, http.StatusOK, recorder.Status())
+	}
+}
+
+func (s *APIServer) handleStart(w http.ResponseWriter, r *http.Request) {
+	recorder := s.recorder(w, r)
+	if recorder == nil {
+		return
+	}
+	if recorder.IsRecording() {
+		writeError(w, http.StatusConflict, "already recording")
+		return
+	}
+	recorder.logger.Info("Starting recording on demand")
+	recorder.Start()
+	writeJSON(w, http.StatusOK, recorder.Status())
+}
+
+func (s *APIServer) handleStop(w http.ResponseWriter, r *http.Request) {
+	recorder := s.recorder(w, r)
+	if recorder == nil {
+		return
+	}
+	if !recorder.IsRecording() {
+		writeError(w, http.StatusConflict, "not recording")
+		return
+	}
+	// 停止录制需要等待 ffmpeg 退出，在后台执行
+	recorder.logger.Info("Stopping recording on demand")
+	go recorder.Stop()
+	writeJSON(w, http.StatusAccepted, recorder.Status())
+}
+
+func (s *APIServer) handleUploads(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"stats": s.queue.Stats(),
+		"tasks": s.queue.Tasks(),
+	})
+}
+
+func (s *APIServer) handleSweep(w http.ResponseWriter, r *http.Request) {
+	queued := make(map[string]int)
+	for _, recorder := range s.supervisor.Recorders() {
+		srcPaths, err := recorder.Sweep()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		queued[recorder.name] = len(srcPaths)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"queued": queued})
+}
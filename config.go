@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile 未指定配置文件时尝试加载的文件，不存在时只使用环境变量
+const defaultConfigFile = "config.json"
+
+// 配置来源，优先级从低到高
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// fieldSpec 配置项的环境变量和默认值，配置项名称为 json 标签组成的路径，如 "upload.keep_local"
+type fieldSpec struct {
+	env    string
+	def    string
+	secret bool                              // 输出配置时隐藏取值
+	parse  func(string) (interface{}, error) // 非基本类型的配置项从字符串解析的方式，默认按 JSON 解析
+}
+
+// fieldSpecs 可以通过环境变量设置的配置项，其他配置项只能通过配置文件或 --set 设置
+var fieldSpecs = map[string]fieldSpec{
+	"camera.name":     {env: "CAMERA_NAME", def: "cam1"},
+	"camera.ip":       {env: "CAMERA_IP", def: "192.168.1.100"},
+	"camera.port":     {env: "CAMERA_PORT", def: "554"},
+	"camera.username": {env: "CAMERA_USERNAME", def: "admin"},
+	"camera.password": {env: "CAMERA_PASSWORD", def: "password", secret: true},
+	"camera.stream":   {env: "CAMERA_STREAM", def: "/cam/realmonitor?channel=1&subtype=0"},
+
+	"recording.output_dir":          {env: "RECORDING_OUTPUT_DIR", def: "recordings"},
+	"recording.segment_time":        {env: "RECORDING_SEGMENT_TIME", def: "300"},
+	"recording.segment_naming":      {env: "RECORDING_SEGMENT_NAMING", def: SegmentNamingTimestamp},
+	"recording.stop_timeout":        {env: "RECORDING_STOP_TIMEOUT", def: "10"},
+	"recording.reconnect_delay":     {env: "RECORDING_RECONNECT_DELAY", def: "5"},
+	"recording.reconnect_max_delay": {env: "RECORDING_RECONNECT_MAX_DELAY", def: "300"},
+	"recording.offline_threshold":   {env: "RECORDING_OFFLINE_THRESHOLD", def: "5"},
+	"recording.rtsp_auth_proxy":     {env: "RECORDING_RTSP_AUTH_PROXY", def: "false"},
+	"recording.start_hour":          {env: "RECORDING_START_HOUR", def: "8"},
+	"recording.start_minute":        {env: "RECORDING_START_MINUTE", def: "0"},
+	"recording.end_hour":            {env: "RECORDING_END_HOUR", def: "18"},
+	"recording.end_minute":          {env: "RECORDING_END_MINUTE", def: "0"},
+	"recording.windows": {env: "RECORDING_SCHEDULE", parse: func(s string) (interface{}, error) {
+		return ParseScheduleWindows(s)
+	}},
+	"recording.timezone": {env: "RECORDING_TIMEZONE"},
+
+	"upload.backend":        {env: "UPLOAD_BACKEND", def: "alist"},
+	"upload.mode":           {env: "UPLOAD_MODE", def: UploadModeSegments},
+	"upload.merge_interval": {env: "UPLOAD_MERGE_INTERVAL", def: "0"},
+	"upload.merge_segments": {env: "UPLOAD_MERGE_SEGMENTS", def: "0"},
+	"upload.retry_count":    {env: "UPLOAD_RETRY_COUNT", def: "3"},
+	"upload.retry_delay":    {env: "UPLOAD_RETRY_DELAY", def: "5"},
+	"upload.keep_local":     {env: "UPLOAD_KEEP_LOCAL", def: "false"},
+	"upload.file_pattern":   {env: "UPLOAD_FILE_PATTERN", def: "merged_*.mkv"},
+	"upload.max_file_age":   {env: "UPLOAD_MAX_FILE_AGE", def: "30"},
+	"upload.alist_url":      {env: "UPLOAD_ALIST_URL", def: "http://localhost:5244"},
+	"upload.alist_user":     {env: "UPLOAD_ALIST_USER", def: "admin"},
+	"upload.alist_pass":     {env: "UPLOAD_ALIST_PASS", def: "password", secret: true},
+	"upload.alist_path":     {env: "UPLOAD_ALIST_PATH", def: "/"},
+	"upload.max_concurrent": {env: "UPLOAD_MAX_CONCURRENT", def: "3"},
+	"upload.shutdown_wait":  {env: "UPLOAD_SHUTDOWN_WAIT", def: "30"},
+	"upload.local_path":     {env: "UPLOAD_LOCAL_PATH"},
+	"upload.webdav_url":     {env: "UPLOAD_WEBDAV_URL"},
+	"upload.webdav_user":    {env: "UPLOAD_WEBDAV_USER"},
+	"upload.webdav_pass":    {env: "UPLOAD_WEBDAV_PASS", secret: true},
+	"upload.s3_endpoint":    {env: "UPLOAD_S3_ENDPOINT"},
+	"upload.s3_region":      {env: "UPLOAD_S3_REGION", def: "us-east-1"},
+	"upload.s3_bucket":      {env: "UPLOAD_S3_BUCKET"},
+	"upload.s3_prefix":      {env: "UPLOAD_S3_PREFIX"},
+	"upload.s3_access_key":  {env: "UPLOAD_S3_ACCESS_KEY"},
+	"upload.s3_secret_key":  {env: "UPLOAD_S3_SECRET_KEY", secret: true},
+	"upload.http_trace":     {env: "UPLOAD_HTTP_TRACE"},
+
+	"http.listen": {env: "HTTP_LISTEN", def: "127.0.0.1:8080"},
+	"http.token":  {env: "HTTP_TOKEN", secret: true},
+
+	"notify.events":           {env: "NOTIFY_EVENTS"},
+	"notify.webhook_url":      {env: "NOTIFY_WEBHOOK_URL"},
+	"notify.smtp_host":        {env: "NOTIFY_SMTP_HOST"},
+	"notify.smtp_port":        {env: "NOTIFY_SMTP_PORT", def: "587"},
+	"notify.smtp_user":        {env: "NOTIFY_SMTP_USER"},
+	"notify.smtp_pass":        {env: "NOTIFY_SMTP_PASS", secret: true},
+	"notify.smtp_from":        {env: "NOTIFY_SMTP_FROM"},
+	"notify.smtp_to":          {env: "NOTIFY_SMTP_TO"},
+	"notify.telegram_token":   {env: "NOTIFY_TELEGRAM_TOKEN", secret: true},
+	"notify.telegram_chat_id": {env: "NOTIFY_TELEGRAM_CHAT_ID"},
+	"notify.serverchan_key":   {env: "NOTIFY_SERVERCHAN_KEY", secret: true},
+
+	"log.level":  {env: "LOG_LEVEL", def: "info"},
+	"log.format": {env: "LOG_FORMAT", def: LogFormatText},
+}
+
+// configField 配置中的一个配置项
+type configField struct {
+	key   string
+	value reflect.Value // 可以设置的字段
+}
+
+// configFields 按结构体中的顺序返回所有配置项，结构体字段展开为子配置项，其余字段（包括切片和指针）作为一个配置项
+func configFields(config *Config) []configField {
+	var fields []configField
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			if sf.Anonymous {
+				// 嵌入的结构体在 JSON 中与外层字段处于同一层
+				walk(v.Field(i), prefix)
+				continue
+			}
+			name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), prefix+name+".")
+				continue
+			}
+			fields = append(fields, configField{key: prefix + name, value: v.Field(i)})
+		}
+	}
+	walk(reflect.ValueOf(config).Elem(), "")
+	return fields
+}
+
+// setField 将字符串解析为配置项的值
+func setField(field configField, value string) error {
+	v := field.value
+	if parse := fieldSpecs[field.key].parse; parse != nil {
+		parsed, err := parse(value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(parsed))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", value)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		v.SetBool(b)
+	default:
+		// 切片和指针类型的配置项（如 cameras）使用 JSON
+		target := reflect.New(v.Type())
+		if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+			return fmt.Errorf("expected JSON: %v", err)
+		}
+		v.Set(target.Elem())
+	}
+	return nil
+}
+
+// loadConfig 按 默认值 < 配置文件 < 环境变量 < 命令行参数 的优先级加载配置
+// configFile 为 --config 参数的值，overrides 为 --set 参数中的 key=value
+// 每一层只覆盖其中出现的配置项，因此 0、false 和空字符串也可以在任意一层设置
+func loadConfig(configFile string, overrides []string) (*Config, error) {
+	config := &Config{sources: make(map[string]string)}
+	fields := configFields(config)
+	byKey := make(map[string]configField, len(fields))
+	for _, field := range fields {
+		byKey[field.key] = field
+		config.sources[field.key] = sourceDefault
+		if def := fieldSpecs[field.key].def; def != "" {
+			if err := setField(field, def); err != nil {
+				return nil, fmt.Errorf("invalid default for %s: %v", field.key, err)
+			}
+		}
+	}
+
+	// 配置文件，未指定时只在 config.json 存在时加载
+	path, explicit := configFilePath(configFile)
+	if _, err := os.Stat(path); err == nil || explicit {
+		fileConfig, present, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range configFields(fileConfig) {
+			if present[field.key] {
+				byKey[field.key].value.Set(field.value)
+				config.sources[field.key] = sourceFile + " " + path
+			}
+		}
+	}
+
+	// 环境变量，空值视为未设置（docker-compose 会把 .env 中没有的变量以空值传入）
+	// 每个环境变量都可以使用 _FILE 后缀从文件读取取值（如 Docker secrets）
+	for _, field := range fields {
+		spec := fieldSpecs[field.key]
+		if spec.env == "" {
+			continue
+		}
+		name := spec.env
+		value := os.Getenv(name)
+		if path := os.Getenv(name + "_FILE"); path != "" {
+			if value != "" {
+				return nil, fmt.Errorf("both %s and %s_FILE are set", name, name)
+			}
+			var err error
+			if value, err = readSecretFile(path); err != nil {
+				return nil, fmt.Errorf("invalid %s_FILE: %v", name, err)
+			}
+			name += "_FILE"
+		}
+		if value == "" {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		config.sources[field.key] = sourceEnv + " " + name
+	}
+
+	// 命令行参数 --set key=value
+	for _, override := range overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --set %q: expected key=value", override)
+		}
+		field, ok := byKey[strings.TrimSpace(key)]
+		if !ok {
+			return nil, fmt.Errorf("invalid --set %q: unknown config key %q", override, key)
+		}
+		if err := setField(field, value); err != nil {
+			return nil, fmt.Errorf("invalid --set %s: %v", field.key, err)
+		}
+		config.sources[field.key] = sourceFlag + " --set"
+	}
+
+	// 摄像头的 password_file 优先于 password
+	if err := config.Camera.readPasswordFile(); err != nil {
+		return nil, fmt.Errorf("camera: %v", err)
+	}
+	if config.Camera.PasswordFile != "" {
+		config.sources["camera.password"] = config.sources["camera.password_file"]
+	}
+	for i := range config.Cameras {
+		if err := config.Cameras[i].readPasswordFile(); err != nil {
+			return nil, fmt.Errorf("cameras[%d]: %v", i, err)
+		}
+	}
+
+	config.Recording.SegmentNaming = strings.ToLower(config.Recording.SegmentNaming)
+	config.Upload.Mode = strings.ToLower(config.Upload.Mode)
+	config.Log.Format = strings.ToLower(config.Log.Format)
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readSecretFile 读取保存在文件中的密码，忽略末尾的换行
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readPasswordFile 设置了 password_file 时从文件读取密码
+func (c *CameraConfig) readPasswordFile() error {
+	if c.PasswordFile == "" {
+		return nil
+	}
+	password, err := readSecretFile(c.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("failed to read password_file: %v", err)
+	}
+	c.Password = password
+	return nil
+}
+
+// secrets 返回配置中所有的密码和令牌，用于在日志中隐藏
+// 未修改的默认值（如 "password"）不是真正的密码，不会被隐藏
+func (c *Config) secrets() []string {
+	var secrets []string
+	for _, field := range configFields(c) {
+		if fieldSpecs[field.key].secret && field.value.Kind() == reflect.String &&
+			c.sources[field.key] != sourceDefault {
+			secrets = append(secrets, field.value.String())
+		}
+	}
+	for _, camera := range c.Cameras {
+		secrets = append(secrets, camera.Password)
+	}
+	return secrets
+}
+
+// Dump 输出每个配置项的实际取值和来源，敏感信息被隐藏
+func (c *Config) Dump(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, field := range configFields(c) {
+		raw := field.value.Interface()
+		if cameras, ok := raw.([]CameraConfig); ok {
+			// 多摄像头配置中的密码同样需要隐藏
+			masked := make([]CameraConfig, len(cameras))
+			for i, camera := range cameras {
+				if camera.Password != "" {
+					camera.Password = "******"
+				}
+				masked[i] = camera
+			}
+			raw = masked
+		}
+
+		var value string
+		switch {
+		case fieldSpecs[field.key].secret && !field.value.IsZero():
+			value = "******"
+		case field.value.Kind() == reflect.String:
+			value = strconv.Quote(field.value.String())
+		default:
+			data, err := json.Marshal(raw)
+			if err != nil {
+				value = fmt.Sprintf("<%v>", err)
+			} else {
+				value = string(data)
+			}
+		}
+		source := c.sources[field.key]
+		if source == "" {
+			source = sourceDefault
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", field.key, value, source)
+	}
+	tw.Flush()
+}
+
+// stringList 可以重复指定的命令行参数
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(s string) error { *l = append(*l, s); return nil }
+
+// configFilePath 返回需要加载的配置文件，优先使用 --config 参数，其次是 CONFIG_FILE 环境变量
+// explicit 为 true 时文件必须存在
+func configFilePath(flagValue string) (path string, explicit bool) {
+	if flagValue != "" {
+		return flagValue, true
+	}
+	if env := os.Getenv("CONFIG_FILE"); env != "" {
+		return env, true
+	}
+	return defaultConfigFile, false
+}
+
+// readConfigFile 读取配置文件，按扩展名识别 JSON、YAML 或 TOML 格式，同时返回文件中出现的配置项
+// 文件中出现未知的配置项时返回错误
+func readConfigFile(path string) (*Config, map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	// YAML 和 TOML 先解析为通用结构再转换为 JSON，统一使用 json 标签中的配置项名称
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		var raw map[string]interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+	case ".toml":
+		var raw map[string]interface{}
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported config format %q (expected .json, .yaml, .yml or .toml)", path, ext)
+	}
+
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, describeDecodeError(data, err))
+	}
+
+	// 记录文件中出现的配置项，只有这些配置项会覆盖默认值
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	present := make(map[string]bool)
+	for _, field := range configFields(&config) {
+		if hasKey(raw, field.key) {
+			present[field.key] = true
+		}
+	}
+	return &config, present, nil
+}
+
+// hasKey 判断 JSON 对象中是否存在 "a.b.c" 形式的配置项，值为 null 时同样视为存在
+func hasKey(raw map[string]interface{}, key string) bool {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		value, ok := raw[part]
+		if !ok {
+			return false
+		}
+		if i == len(parts)-1 {
+			return true
+		}
+		if raw, ok = value.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return false
+}
+
+// describeDecodeError 为 JSON 解析错误补充出错的配置项或行号
+func describeDecodeError(data []byte, err error) error {
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return fmt.Errorf("unknown config key %s", field)
+	}
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		if typeErr.Field != "" {
+			err = fmt.Errorf("%s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return err
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %v", line, err)
+}
+
+// Validate 检查配置取值，返回所有不合法的配置项
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// 摄像头
+	cameras := c.Cameras
+	if len(cameras) == 0 {
+		cameras = []CameraConfig{c.Camera}
+	}
+	for i, camera := range cameras {
+		prefix := "camera"
+		if len(c.Cameras) > 0 {
+			prefix = fmt.Sprintf("cameras[%d]", i)
+		}
+		if camera.Port != "" {
+			if port, err := strconv.Atoi(camera.Port); err != nil || port < 1 || port > 65535 {
+				add("%s.port: invalid port %q", prefix, camera.Port)
+			}
+		}
+		if camera.Schedule != nil {
+			validateSchedule(prefix+".schedule", camera.Schedule, add)
+		}
+	}
+
+	// 录制
+	if c.Recording.SegmentTime <= 0 {
+		add("recording.segment_time must be positive, got %d", c.Recording.SegmentTime)
+	}
+	if c.Recording.StopTimeout < 0 {
+		add("recording.stop_timeout must not be negative, got %d", c.Recording.StopTimeout)
+	}
+	if c.Recording.ReconnectDelay < 0 {
+		add("recording.reconnect_delay must not be negative, got %d", c.Recording.ReconnectDelay)
+	}
+	if c.Recording.ReconnectMaxDelay < 0 {
+		add("recording.reconnect_max_delay must not be negative, got %d", c.Recording.ReconnectMaxDelay)
+	}
+	if c.Recording.OfflineThreshold < 0 {
+		add("recording.offline_threshold must not be negative, got %d", c.Recording.OfflineThreshold)
+	}
+	validateSchedule("recording", &c.Recording.ScheduleConfig, add)
+
+	// 上传
+	for _, field := range []struct {
+		name  string
+		value int
+	}{
+		{"upload.merge_interval", c.Upload.MergeInterval},
+		{"upload.merge_segments", c.Upload.MergeSegments},
+		{"upload.retry_count", c.Upload.RetryCount},
+		{"upload.retry_delay", c.Upload.RetryDelay},
+		{"upload.max_file_age", c.Upload.MaxFileAge},
+		{"upload.max_concurrent", c.Upload.MaxConcurrent},
+		{"upload.shutdown_wait", c.Upload.ShutdownWait},
+	} {
+		if field.value < 0 {
+			add("%s must not be negative, got %d", field.name, field.value)
+		}
+	}
+	switch strings.ToLower(c.Upload.Backend) {
+	case "", "alist":
+		validateURL("upload.alist_url", c.Upload.AlistURL, true, add)
+	case "webdav":
+		validateURL("upload.webdav_url", c.Upload.WebDAVURL, true, add)
+	case "s3":
+		validateURL("upload.s3_endpoint", c.Upload.S3Endpoint, true, add)
+	}
+	for _, name := range splitList(c.Upload.HTTPTrace) {
+		valid := strings.EqualFold(name, httpTraceAll)
+		for _, backend := range httpTraceBackends {
+			valid = valid || strings.EqualFold(name, backend)
+		}
+		if !valid {
+			add("upload.http_trace: unknown backend %q (expected %s or %s)", name, strings.Join(httpTraceBackends, ", "), httpTraceAll)
+		}
+	}
+
+	// 通知
+	if _, err := parseNotifyEvents(c.Notify.Events); err != nil {
+		add("notify.events: %v", err)
+	}
+	validateURL("notify.webhook_url", c.Notify.WebhookURL, false, add)
+	if c.Notify.SMTPHost != "" && (c.Notify.SMTPPort < 1 || c.Notify.SMTPPort > 65535) {
+		add("notify.smtp_port: invalid port %d", c.Notify.SMTPPort)
+	}
+
+	// 日志
+	if _, err := parseLogLevel(c.Log.Level); err != nil {
+		add("log.level: %v", err)
+	}
+	switch c.Log.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		add("log.format: unknown format %q (expected text or json)", c.Log.Format)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// validateSchedule 检查录制时间段，窗口和时区的格式由 NewSchedule 检查
+func validateSchedule(prefix string, s *ScheduleConfig, add func(string, ...interface{})) {
+	valid := true
+	check := func(name string, value, max int) {
+		if value < 0 || value > max {
+			add("%s.%s must be between 0 and %d, got %d", prefix, name, max, value)
+			valid = false
+		}
+	}
+	check("start_hour", s.StartHour, 23)
+	check("start_minute", s.StartMinute, 59)
+	check("end_hour", s.EndHour, 24)
+	check("end_minute", s.EndMinute, 59)
+	if s.EndHour == 24 && s.EndMinute != 0 {
+		add("%s.end_minute must be 0 when end_hour is 24", prefix)
+		valid = false
+	}
+	if !valid {
+		return
+	}
+	if _, err := NewSchedule(s); err != nil {
+		add("%s: %v", prefix, err)
+	}
+}
+
+// validateURL 检查 http/https 地址，required 为 false 时允许为空
+func validateURL(name, value string, required bool, add func(string, ...interface{})) {
+	if value == "" {
+		if required {
+			add("%s is required", name)
+		}
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		add("%s: malformed URL %q: %v", name, value, err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("%s: malformed URL %q (expected http:// or https://)", name, value)
+	}
+}
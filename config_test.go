@@ -0,0 +1,208 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearConfigEnv 清空所有配置相关的环境变量，空值视为未设置
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, spec := range fieldSpecs {
+		if spec.env != "" {
+			t.Setenv(spec.env, "")
+			t.Setenv(spec.env+"_FILE", "")
+		}
+	}
+}
+
+// writeConfigFile 在临时目录中写入配置文件并返回其路径
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	secretFile := writeConfigFile(t, "segment_time", "1200\n")
+	tests := []struct {
+		name       string
+		file       string
+		env        map[string]string
+		set        []string
+		want       int
+		wantSource string
+	}{
+		{name: "default", file: `{}`, want: 300, wantSource: "default"},
+		{name: "file", file: `{"recording": {"segment_time": 600}}`, want: 600, wantSource: "file"},
+		{
+			name:       "env over file",
+			file:       `{"recording": {"segment_time": 600}}`,
+			env:        map[string]string{"RECORDING_SEGMENT_TIME": "900"},
+			want:       900,
+			wantSource: "env RECORDING_SEGMENT_TIME",
+		},
+		{
+			name:       "empty env is unset",
+			file:       `{"recording": {"segment_time": 600}}`,
+			env:        map[string]string{"RECORDING_SEGMENT_TIME": ""},
+			want:       600,
+			wantSource: "file",
+		},
+		{
+			name:       "_FILE over file",
+			file:       `{"recording": {"segment_time": 600}}`,
+			env:        map[string]string{"RECORDING_SEGMENT_TIME_FILE": secretFile},
+			want:       1200,
+			wantSource: "env RECORDING_SEGMENT_TIME_FILE",
+		},
+		{
+			name:       "--set over env",
+			file:       `{"recording": {"segment_time": 600}}`,
+			env:        map[string]string{"RECORDING_SEGMENT_TIME": "900"},
+			set:        []string{"recording.segment_time=1500"},
+			want:       1500,
+			wantSource: "flag --set",
+		},
+		{
+			name:       "--set over _FILE",
+			file:       `{}`,
+			env:        map[string]string{"RECORDING_SEGMENT_TIME_FILE": secretFile},
+			set:        []string{"recording.segment_time=1500"},
+			want:       1500,
+			wantSource: "flag --set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := writeConfigFile(t, "config.json", tt.file)
+			config, err := loadConfig(path, tt.set)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Recording.SegmentTime != tt.want {
+				t.Errorf("segment_time = %d, want %d", config.Recording.SegmentTime, tt.want)
+			}
+			wantSource := tt.wantSource
+			if wantSource == "file" {
+				wantSource = "file " + path
+			}
+			if got := config.sources["recording.segment_time"]; got != wantSource {
+				t.Errorf("source = %q, want %q", got, wantSource)
+			}
+		})
+	}
+}
+
+func TestLoadConfigZeroValues(t *testing.T) {
+	// 配置文件中显式设置的 0、false 和空字符串覆盖非零的默认值，三种格式的结果相同
+	files := map[string]string{
+		"config.json": `{"recording": {"reconnect_delay": 0, "rtsp_auth_proxy": false}, "upload": {"retry_count": 0}, "http": {"listen": ""}}`,
+		"config.yaml": "recording:\n  reconnect_delay: 0\n  rtsp_auth_proxy: false\nupload:\n  retry_count: 0\nhttp:\n  listen: \"\"\n",
+		"config.toml": "[recording]\nreconnect_delay = 0\nrtsp_auth_proxy = false\n[upload]\nretry_count = 0\n[http]\nlisten = \"\"\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearConfigEnv(t)
+			path := writeConfigFile(t, name, content)
+			config, err := loadConfig(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Recording.ReconnectDelay != 0 || config.Upload.RetryCount != 0 || config.HTTP.Listen != "" {
+				t.Errorf("reconnect_delay = %d, retry_count = %d, http.listen = %q, want zero values",
+					config.Recording.ReconnectDelay, config.Upload.RetryCount, config.HTTP.Listen)
+			}
+			for _, key := range []string{"recording.reconnect_delay", "recording.rtsp_auth_proxy", "upload.retry_count", "http.listen"} {
+				if got := config.sources[key]; got != "file "+path {
+					t.Errorf("%s source = %q, want file", key, got)
+				}
+			}
+			// 文件中没有的配置项保留默认值
+			if config.Upload.RetryDelay != 5 || config.sources["upload.retry_delay"] != sourceDefault {
+				t.Errorf("retry_delay = %d from %s, want default 5", config.Upload.RetryDelay, config.sources["upload.retry_delay"])
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		set     []string
+		wantErr string
+	}{
+		{name: "unknown key in file", file: `{"recording": {"segment_tim": 60}}`, wantErr: `unknown config key "segment_tim"`},
+		{name: "unknown section in file", file: `{"uplaod": {}}`, wantErr: `unknown config key "uplaod"`},
+		{name: "unknown --set key", file: `{}`, set: []string{"upload.retry=1"}, wantErr: `unknown config key "upload.retry"`},
+		{name: "--set without value", file: `{}`, set: []string{"upload.retry_count"}, wantErr: "expected key=value"},
+		{name: "invalid env", file: `{}`, env: map[string]string{"UPLOAD_MAX_CONCURRENT": "3  # comment"}, wantErr: "invalid UPLOAD_MAX_CONCURRENT"},
+		{
+			name:    "env and _FILE",
+			file:    `{}`,
+			env:     map[string]string{"CAMERA_PASSWORD": "a", "CAMERA_PASSWORD_FILE": "/nonexistent"},
+			wantErr: "both CAMERA_PASSWORD and CAMERA_PASSWORD_FILE are set",
+		},
+		{name: "invalid value", file: `{"recording": {"segment_time": 0}}`, wantErr: "recording.segment_time must be positive"},
+		{name: "unknown notify event", file: `{}`, env: map[string]string{"NOTIFY_EVENTS": "upload_faild"}, wantErr: `notify.events: unknown event "upload_faild"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := loadConfig(writeConfigFile(t, "config.json", tt.file), tt.set)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigDumpRedactsSecrets(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CAMERA_PASSWORD", "env-camera-secret")
+	t.Setenv("UPLOAD_S3_SECRET_KEY_FILE", writeConfigFile(t, "s3_secret", "file-s3-secret\n"))
+	path := writeConfigFile(t, "config.json", `{
+		"upload": {"alist_pass": "file-alist-secret"},
+		"http": {"token": "file-http-token"},
+		"cameras": [{"name": "cam2", "ip": "10.0.0.2", "password": "file-cameras-secret"}]
+	}`)
+	config, err := loadConfig(path, []string{"notify.smtp_pass=flag-smtp-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	config.Dump(&out)
+	dump := out.String()
+	for _, secret := range []string{"env-camera-secret", "file-s3-secret", "file-alist-secret", "file-http-token", "file-cameras-secret", "flag-smtp-secret"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("dump-config output contains %q:\n%s", secret, dump)
+		}
+	}
+	for _, line := range []string{"camera.password", "upload.s3_secret_key", "notify.smtp_pass"} {
+		if !strings.Contains(dump, line) {
+			t.Errorf("dump-config output is missing %s", line)
+		}
+	}
+	if got := strings.Count(dump, "******"); got < 6 {
+		t.Errorf("dump-config output has %d masked values, want at least 6:\n%s", got, dump)
+	}
+	if !strings.Contains(dump, "env UPLOAD_S3_SECRET_KEY_FILE") {
+		t.Errorf("dump-config output does not show the _FILE source:\n%s", dump)
+	}
+}
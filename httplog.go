@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// httpTraceAll upload.http_trace 中表示跟踪所有后端
+const httpTraceAll = "all"
+
+// httpTraceBackends 支持跟踪 HTTP 请求的存储后端
+var httpTraceBackends = []string{"alist", "webdav", "s3"}
+
+// redactedHeaders 跟踪请求时隐藏取值的头部，如 Alist 的 token 和 WebDAV 的 Basic 认证
+var redactedHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Amz-Security-Token": true,
+}
+
+// httpTraceEnabled 判断 upload.http_trace 是否开启了指定后端的请求跟踪
+func httpTraceEnabled(config *UploadConfig, backend string) bool {
+	for _, name := range splitList(config.HTTPTrace) {
+		if strings.EqualFold(name, backend) || strings.EqualFold(name, httpTraceAll) {
+			return true
+		}
+	}
+	return false
+}
+
+// newHTTPClient 创建存储后端使用的 HTTP 客户端，开启跟踪时在 debug 级别记录每个请求和响应
+func newHTTPClient(config *UploadConfig, backend string) *http.Client {
+	client := &http.Client{}
+	if httpTraceEnabled(config, backend) {
+		client.Transport = &tracingTransport{
+			base:   http.DefaultTransport,
+			logger: slog.With("backend", backend),
+		}
+	}
+	return client
+}
+
+// tracingTransport 记录请求和响应的 http.RoundTripper，不记录请求和响应内容，敏感头部被隐藏
+type tracingTransport struct {
+	base   http.RoundTripper
+	logger *slog.Logger
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return t.base.RoundTrip(req)
+	}
+
+	logger := t.logger.With("method", req.Method, "url", redact(req.URL.String()))
+	logger.Debug("HTTP request", "content_length", req.ContentLength, "headers", formatHeaders(req.Header))
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	duration := time.Since(start)
+	if err != nil {
+		logger.Debug("HTTP request failed", "duration", duration.String(), "error", err)
+		return nil, err
+	}
+	logger.Debug("HTTP response", "status", resp.StatusCode, "duration", duration.String(),
+		"content_length", resp.ContentLength, "headers", formatHeaders(resp.Header))
+	return resp, nil
+}
+
+// formatHeaders 按名称排序输出头部，敏感头部的取值显示为 ******
+func formatHeaders(header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = redactedValue
+		}
+		parts = append(parts, name+": "+value)
+	}
+	return strings.Join(parts, "; ")
+}
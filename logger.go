@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 日志格式
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level  string `json:"level"`  // debug、info、warn 或 error
+	Format string `json:"format"` // text（默认）或 json
+}
+
+// logLevel 全局日志级别，重新加载配置时可以直接修改
+var logLevel slog.LevelVar
+
+// parseLogLevel 解析日志级别，为空时使用 info
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s != "" {
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return level, fmt.Errorf("invalid log level %q: %v", s, err)
+		}
+	}
+	return level, nil
+}
+
+// setupLogger 按配置创建全局 slog 日志，标准库 log 的输出也会转到该日志
+func setupLogger(config *LogConfig) error {
+	level, err := parseLogLevel(config.Level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+
+	options := &slog.HandlerOptions{Level: &logLevel, ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	switch strings.ToLower(config.Format) {
+	case "", LogFormatText:
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("unknown log format: %s", config.Format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// redactedValue 替换密码后显示的内容
+const redactedValue = "******"
+
+// minSecretLength 短于该长度的密码不在日志中替换，避免误伤普通内容（URL 中的凭据始终会被隐藏）
+const minSecretLength = 4
+
+// urlCredentials 匹配 URL 中的 user:password@ 部分，如 ffmpeg 输出的 rtsp 地址
+var urlCredentials = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*):[^@/\s]*@`)
+
+// secretReplacer 替换日志中出现的密码，重新加载配置时整体替换
+var secretReplacer atomic.Pointer[strings.Replacer]
+
+// setLogSecrets 设置需要在日志中隐藏的密码和令牌，包括它们经过 URL 编码后的形式
+func setLogSecrets(secrets []string) {
+	seen := make(map[string]bool)
+	var values []string
+	for _, secret := range secrets {
+		for _, value := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+			if len(value) >= minSecretLength && !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	// 按参数顺序匹配，较长的密码优先
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		pairs = append(pairs, value, redactedValue)
+	}
+	secretReplacer.Store(strings.NewReplacer(pairs...))
+}
+
+// redact 隐藏字符串中的密码和 URL 中的凭据
+func redact(s string) string {
+	s = urlCredentials.ReplaceAllString(s, "$1:"+redactedValue+"@")
+	if replacer := secretReplacer.Load(); replacer != nil {
+		s = replacer.Replace(s)
+	}
+	return s
+}
+
+// redactAttr 输出日志前隐藏消息和字段中的敏感信息，包括 error 等非字符串字段
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString, slog.KindAny:
+		s := a.Value.String()
+		if redacted := redact(s); redacted != s {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+// ffmpegLogWriter 将 ffmpeg 的输出按行写入日志
+// ffmpeg 使用 -loglevel level+... 启动，每行带有 [info]、[warning] 等级别标记，按标记映射日志级别
+type ffmpegLogWriter struct {
+	logger *slog.Logger
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// maxFFmpegLogLine 单行最大长度，超过时直接输出，避免缓冲区无限增长
+const maxFFmpegLogLine = 4096
+
+func newFFmpegLogWriter(logger *slog.Logger) *ffmpegLogWriter {
+	return &ffmpegLogWriter{logger: logger.With("source", "ffmpeg")}
+}
+
+func (w *ffmpegLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		// 进度信息以 \r 结尾，同样按行处理
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.logLine(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxFFmpegLogLine {
+		w.logLine(string(w.buf))
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Flush 输出缓冲区中不完整的最后一行
+func (w *ffmpegLogWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.logLine(string(w.buf))
+	w.buf = nil
+}
+
+var ffmpegLogLevels = []struct {
+	tag   string
+	level slog.Level
+}{
+	{"[panic] ", slog.LevelError},
+	{"[fatal] ", slog.LevelError},
+	{"[error] ", slog.LevelError},
+	{"[warning] ", slog.LevelWarn},
+	{"[info] ", slog.LevelDebug},
+	{"[verbose] ", slog.LevelDebug},
+	{"[debug] ", slog.LevelDebug},
+	{"[trace] ", slog.LevelDebug},
+}
+
+func (w *ffmpegLogWriter) logLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	// 没有级别标记的行（如启动信息）按 debug 输出
+	level := slog.LevelDebug
+	for _, l := range ffmpegLogLevels {
+		if i := strings.Index(line, l.tag); i >= 0 {
+			level = l.level
+			line = line[:i] + line[i+len(l.tag):]
+			break
+		}
+	}
+	w.logger.Log(context.Background(), level, "ffmpeg: "+line)
+}
@@ -1,40 +1,69 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type Config struct {
-	Camera struct {
-		IP       string `json:"ip"`
-		Port     string `json:"port"`
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Stream   string `json:"stream"`
-	} `json:"camera"`
+	Camera    CameraConfig   `json:"camera"`  // 单摄像头配置（兼容旧版本）
+	Cameras   []CameraConfig `json:"cameras"` // 多摄像头配置，非空时忽略 camera
 	Recording struct {
-		OutputDir   string `json:"output_dir"`
-		SegmentTime int    `json:"segment_time"`
-		StartHour   int    `json:"start_hour"`
-		StartMinute int    `json:"start_minute"`
-		EndHour     int    `json:"end_hour"`
-		EndMinute   int    `json:"end_minute"`
+		OutputDir         string `json:"output_dir"`
+		SegmentTime       int    `json:"segment_time"`
+		SegmentNaming     string `json:"segment_naming"`      // timestamp（默认）或 sequence
+		StopTimeout       int    `json:"stop_timeout"`        // 等待 ffmpeg 正常退出的秒数，超时后强制结束
+		ReconnectDelay    int    `json:"reconnect_delay"`     // 首次重连等待秒数，之后按指数退避
+		ReconnectMaxDelay int    `json:"reconnect_max_delay"` // 重连等待的最大秒数
+		OfflineThreshold  int    `json:"offline_threshold"`   // 连续失败多少次后标记摄像头离线并告警
+		RTSPAuthProxy     bool   `json:"rtsp_auth_proxy"`     // 通过本地代理认证，ffmpeg 的命令行中不包含密码
+		ScheduleConfig           // 默认录制计划
 	} `json:"recording"`
 	Upload UploadConfig `json:"upload"`
+	HTTP   struct {
+		Listen string `json:"listen"` // HTTP 接口监听地址，为空时不启动
+		Token  string `json:"token"`  // 设置后 POST 接口需要 Authorization: Bearer <token>
+	} `json:"http"`
+	Notify NotifyConfig `json:"notify"`
+	Log    LogConfig    `json:"log"`
+
+	sources map[string]string // 每个配置项的来源，用于 dump-config
+}
+
+// CameraConfig 单个摄像头配置
+type CameraConfig struct {
+	Name         string          `json:"name"`
+	IP           string          `json:"ip"`
+	Port         string          `json:"port"`
+	Username     string          `json:"username"`
+	Password     string          `json:"password"`
+	PasswordFile string          `json:"password_file"` // 从文件读取密码（如 Docker secrets），优先于 password
+	Stream       string          `json:"stream"`
+	OutputDir    string          `json:"output_dir"` // 录制子目录，默认为摄像头名称
+	Schedule     *ScheduleConfig `json:"schedule"`   // 为空时使用 recording 中的录制计划
 }
 
 type UploadConfig struct {
+	Backend       string `json:"backend"`        // alist、local、webdav 或 s3
+	Mode          string `json:"mode"`           // segments、merged 或 both
+	MergeInterval int    `json:"merge_interval"` // 滚动合并的时间段（分钟），如 60 表示每小时合并一次
+	MergeSegments int    `json:"merge_segments"` // 每 N 个片段合并一次，merge_interval 优先
 	RetryCount    int    `json:"retry_count"`
 	RetryDelay    int    `json:"retry_delay"`
 	KeepLocal     bool   `json:"keep_local"`
@@ -45,309 +74,283 @@ type UploadConfig struct {
 	AlistPass     string `json:"alist_pass"`
 	AlistPath     string `json:"alist_path"`
 	MaxConcurrent int    `json:"max_concurrent"`
+	ShutdownWait  int    `json:"shutdown_wait"` // 退出时等待上传完成的最长秒数
+	LocalPath     string `json:"local_path"`
+	WebDAVURL     string `json:"webdav_url"`
+	WebDAVUser    string `json:"webdav_user"`
+	WebDAVPass    string `json:"webdav_pass"`
+	S3Endpoint    string `json:"s3_endpoint"`
+	S3Region      string `json:"s3_region"`
+	S3Bucket      string `json:"s3_bucket"`
+	S3Prefix      string `json:"s3_prefix"`
+	S3AccessKey   string `json:"s3_access_key"`
+	S3SecretKey   string `json:"s3_secret_key"`
+	HTTPTrace     string `json:"http_trace"` // 在 debug 日志中跟踪 HTTP 请求的后端，逗号分隔，如 alist,webdav，all 表示全部
 }
 
 type Recorder struct {
-	rtspURL     string
-	outputDir   string
-	segmentTime int
-	stopChan    chan struct{}
-	sequence    int
-	currentCmd  *exec.Cmd
-	isWindows   bool
-	startTime   time.Time
-	endTime     time.Time
-	retryCount  int
-	isRecording bool
-	startChan   chan struct{}
-	mu          sync.Mutex // 添加互斥锁
-	uploader    *FileUploader
+	name             string
+	logger           *slog.Logger // 带有 camera 字段的日志
+	rtspURL          string
+	authProxy        *RTSPAuthProxy // 为空时凭据直接写在 rtspURL 中
+	outputDir        string
+	segmentTime      int
+	segmentNaming    string
+	stopTimeout      time.Duration
+	stopChan         chan struct{}
+	sequence         int // sequence 命名时下一个片段的序号
+	currentCmd       *exec.Cmd
+	cmdStdin         io.WriteCloser // ffmpeg 的标准输入，写入 "q" 让其正常退出
+	cmdDone          chan struct{}  // ffmpeg 进程退出后关闭
+	cmdErr           error          // ffmpeg 进程的退出错误
+	isWindows        bool
+	schedule         atomic.Pointer[Schedule] // 只在不录制时替换
+	pendingSchedule  *Schedule                // 重新加载配置后等待生效的录制计划
+	clock            Clock
+	startTime        time.Time // 当前或下一个录制窗口的开始时间
+	endTime          time.Time // 当前或下一个录制窗口的结束时间
+	retryCount       int       // 连续失败次数，产生新片段后重置
+	cmdStarted       time.Time
+	reconnectBase    time.Duration
+	reconnectMax     time.Duration
+	offlineThreshold int
+	offline          bool // 连续失败达到阈值后标记为离线
+	offlineSince     time.Time
+	probe            *RTSPProbe
+	probeErr         error // 最近一次探测摄像头的错误
+	isRecording      bool
+	stopping         bool
+	stopDone         chan struct{} // 正在进行的 Stop 完成后关闭
+	recordingDone    chan struct{} // 录制协程退出后关闭
+	mu               sync.Mutex    // 添加互斥锁
+	mergeMu          sync.Mutex    // 串行执行片段合并
+	uploader         *FileUploader
+	queue            *UploadQueue
+	sessionDate      string // 本次录制的日期，用于上传目录
+	currentSegment   string // 正在写入的片段文件名
 }
 
-func loadConfig() (*Config, error) {
-	config := &Config{}
-
-	// 从环境变量加载摄像头配置
-	config.Camera.IP = getEnvOrDefault("CAMERA_IP", "192.168.1.100")
-	config.Camera.Port = getEnvOrDefault("CAMERA_PORT", "554")
-	config.Camera.Username = getEnvOrDefault("CAMERA_USERNAME", "admin")
-	config.Camera.Password = getEnvOrDefault("CAMERA_PASSWORD", "password")
-	config.Camera.Stream = getEnvOrDefault("CAMERA_STREAM", "/cam/realmonitor?channel=1&subtype=0")
-
-	// 从环境变量加载录制配置
-	config.Recording.OutputDir = getEnvOrDefault("RECORDING_OUTPUT_DIR", "recordings")
-	config.Recording.SegmentTime = getEnvIntOrDefault("RECORDING_SEGMENT_TIME", 300)
-	config.Recording.StartHour = getEnvIntOrDefault("RECORDING_START_HOUR", 8)
-	config.Recording.StartMinute = getEnvIntOrDefault("RECORDING_START_MINUTE", 0)
-	config.Recording.EndHour = getEnvIntOrDefault("RECORDING_END_HOUR", 18)
-	config.Recording.EndMinute = getEnvIntOrDefault("RECORDING_END_MINUTE", 0)
-
-	// 从环境变量加载上传配置
-	config.Upload.RetryCount = getEnvIntOrDefault("UPLOAD_RETRY_COUNT", 3)
-	config.Upload.RetryDelay = getEnvIntOrDefault("UPLOAD_RETRY_DELAY", 5)
-	config.Upload.KeepLocal = getEnvBoolOrDefault("UPLOAD_KEEP_LOCAL", true)
-	config.Upload.FilePattern = getEnvOrDefault("UPLOAD_FILE_PATTERN", "merged_*.mkv")
-	config.Upload.MaxFileAge = getEnvIntOrDefault("UPLOAD_MAX_FILE_AGE", 30)
-	config.Upload.AlistURL = getEnvOrDefault("UPLOAD_ALIST_URL", "http://localhost:5244")
-	config.Upload.AlistUser = getEnvOrDefault("UPLOAD_ALIST_USER", "admin")
-	config.Upload.AlistPass = getEnvOrDefault("UPLOAD_ALIST_PASS", "password")
-	config.Upload.AlistPath = getEnvOrDefault("UPLOAD_ALIST_PATH", "/")
-	config.Upload.MaxConcurrent = getEnvIntOrDefault("UPLOAD_MAX_CONCURRENT", 3)
-
-	// 打印实际使用的配置
-	log.Printf("Using configuration:")
-	log.Printf("Camera: IP=%s, Port=%s, Username=%s, Stream=%s",
-		config.Camera.IP, config.Camera.Port, config.Camera.Username, config.Camera.Stream)
-	log.Printf("Recording: OutputDir=%s, SegmentTime=%d, Start=%02d:%02d, End=%02d:%02d",
-		config.Recording.OutputDir, config.Recording.SegmentTime,
-		config.Recording.StartHour, config.Recording.StartMinute,
-		config.Recording.EndHour, config.Recording.EndMinute)
-	log.Printf("Upload: RetryCount=%d, RetryDelay=%d, KeepLocal=%v, FilePattern=%s, MaxFileAge=%d",
-		config.Upload.RetryCount, config.Upload.RetryDelay, config.Upload.KeepLocal,
-		config.Upload.FilePattern, config.Upload.MaxFileAge)
-	log.Printf("Alist: URL=%s, User=%s, Path=%s",
-		config.Upload.AlistURL, config.Upload.AlistUser, config.Upload.AlistPath)
-
-	// 尝试从文件加载配置（如果存在）
-	if _, err := os.Stat("config.json"); err == nil {
-		file, err := os.ReadFile("config.json")
-		if err == nil {
-			// 解析 JSON 配置
-			var fileConfig Config
-			if err := json.Unmarshal(file, &fileConfig); err == nil {
-				// 使用文件配置覆盖默认值和环境变量（如果文件中有相应配置）
-				mergeConfig(config, &fileConfig)
-			}
-		}
+// logSummary 打印实际使用的配置
+func (c *Config) logSummary(cameras []CameraConfig) {
+	slog.Info("Using configuration",
+		"recording.output_dir", c.Recording.OutputDir,
+		"recording.segment_time", c.Recording.SegmentTime,
+		"recording.segment_naming", c.Recording.SegmentNaming)
+	slog.Info("Upload configuration",
+		"backend", c.Upload.Backend, "mode", c.Upload.Mode,
+		"retry_count", c.Upload.RetryCount, "retry_delay", c.Upload.RetryDelay,
+		"keep_local", c.Upload.KeepLocal, "file_pattern", c.Upload.FilePattern, "max_file_age", c.Upload.MaxFileAge)
+	slog.Info("Alist configuration",
+		"url", c.Upload.AlistURL, "user", c.Upload.AlistUser, "path", c.Upload.AlistPath)
+	slog.Info("HTTP configuration", "listen", c.HTTP.Listen, "token", c.HTTP.Token != "")
+	if c.HTTP.Listen != "" && c.HTTP.Token == "" && !isLoopbackListen(c.HTTP.Listen) {
+		slog.Warn("HTTP API is reachable from the network without a token, anyone can start or stop recording; set HTTP_TOKEN",
+			"listen", c.HTTP.Listen)
+	}
+	for _, camera := range cameras {
+		slog.Info("Camera configuration", "camera", camera.Name,
+			"ip", camera.IP, "port", camera.Port, "username", camera.Username, "stream", camera.Stream)
 	}
-
-	return config, nil
 }
 
-// 从环境变量获取字符串值，如果不存在则返回默认值
-func getEnvOrDefault(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// CameraList 返回需要录制的摄像头列表，并补全缺省字段
+func (c *Config) CameraList() ([]CameraConfig, error) {
+	switch c.Recording.SegmentNaming {
+	case "":
+		c.Recording.SegmentNaming = SegmentNamingTimestamp
+	case SegmentNamingTimestamp, SegmentNamingSequence:
+	default:
+		return nil, fmt.Errorf("unknown recording.segment_naming: %s", c.Recording.SegmentNaming)
 	}
-	return defaultValue
-}
 
-// 从环境变量获取整数值，如果不存在或无效则返回默认值
-func getEnvIntOrDefault(key string, defaultValue int) int {
-	if value, exists := os.LookupEnv(key); exists {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+	cameras := c.Cameras
+	if len(cameras) == 0 {
+		cameras = []CameraConfig{c.Camera}
 	}
-	return defaultValue
-}
 
-// 从环境变量获取布尔值，如果不存在或无效则返回默认值
-func getEnvBoolOrDefault(key string, defaultValue bool) bool {
-	if value, exists := os.LookupEnv(key); exists {
-		if boolValue, err := strconv.ParseBool(value); err == nil {
-			return boolValue
+	result := make([]CameraConfig, 0, len(cameras))
+	seen := make(map[string]bool)
+	for i, camera := range cameras {
+		if camera.Name == "" {
+			camera.Name = fmt.Sprintf("cam%d", i+1)
 		}
-	}
-	return defaultValue
-}
-
-// 合并配置，用源配置中的非零值覆盖目标配置
-func mergeConfig(dst, src *Config) {
-	// 合并摄像头配置
-	if src.Camera.IP != "" {
-		dst.Camera.IP = src.Camera.IP
-	}
-	if src.Camera.Port != "" {
-		dst.Camera.Port = src.Camera.Port
-	}
-	if src.Camera.Username != "" {
-		dst.Camera.Username = src.Camera.Username
-	}
-	if src.Camera.Password != "" {
-		dst.Camera.Password = src.Camera.Password
-	}
-	if src.Camera.Stream != "" {
-		dst.Camera.Stream = src.Camera.Stream
-	}
+		if seen[camera.Name] {
+			return nil, fmt.Errorf("duplicate camera name: %s", camera.Name)
+		}
+		seen[camera.Name] = true
 
-	// 合并录制配置
-	if src.Recording.OutputDir != "" {
-		dst.Recording.OutputDir = src.Recording.OutputDir
-	}
-	if src.Recording.SegmentTime != 0 {
-		dst.Recording.SegmentTime = src.Recording.SegmentTime
-	}
-	if src.Recording.StartHour != 0 {
-		dst.Recording.StartHour = src.Recording.StartHour
-	}
-	if src.Recording.StartMinute != 0 {
-		dst.Recording.StartMinute = src.Recording.StartMinute
-	}
-	if src.Recording.EndHour != 0 {
-		dst.Recording.EndHour = src.Recording.EndHour
-	}
-	if src.Recording.EndMinute != 0 {
-		dst.Recording.EndMinute = src.Recording.EndMinute
+		if camera.IP == "" {
+			return nil, fmt.Errorf("camera %s: ip is required", camera.Name)
+		}
+		if camera.Port == "" {
+			camera.Port = "554"
+		}
+		if camera.OutputDir == "" {
+			camera.OutputDir = camera.Name
+		}
+		if camera.Schedule == nil {
+			schedule := c.Recording.ScheduleConfig
+			camera.Schedule = &schedule
+		} else if camera.Schedule.Timezone == "" {
+			camera.Schedule.Timezone = c.Recording.Timezone
+		}
+		if _, err := NewSchedule(camera.Schedule); err != nil {
+			return nil, fmt.Errorf("camera %s: invalid schedule: %v", camera.Name, err)
+		}
+		result = append(result, camera)
 	}
+	return result, nil
+}
 
-	// 合并上传配置
-	if src.Upload.RetryCount != 0 {
-		dst.Upload.RetryCount = src.Upload.RetryCount
-	}
-	if src.Upload.RetryDelay != 0 {
-		dst.Upload.RetryDelay = src.Upload.RetryDelay
-	}
-	if src.Upload.FilePattern != "" {
-		dst.Upload.FilePattern = src.Upload.FilePattern
-	}
-	if src.Upload.MaxFileAge != 0 {
-		dst.Upload.MaxFileAge = src.Upload.MaxFileAge
-	}
-	if src.Upload.AlistURL != "" {
-		dst.Upload.AlistURL = src.Upload.AlistURL
-	}
-	if src.Upload.AlistUser != "" {
-		dst.Upload.AlistUser = src.Upload.AlistUser
-	}
-	if src.Upload.AlistPass != "" {
-		dst.Upload.AlistPass = src.Upload.AlistPass
+// cameraURL 返回带有凭据的 RTSP 地址，用户名和密码中的 @、:、/、# 等字符经过转义
+func cameraURL(camera CameraConfig) string {
+	path, query, _ := strings.Cut(camera.Stream, "?")
+	u := url.URL{
+		Scheme:   "rtsp",
+		Host:     net.JoinHostPort(camera.IP, camera.Port),
+		Path:     "/" + strings.TrimPrefix(path, "/"),
+		RawQuery: query,
 	}
-	if src.Upload.AlistPath != "" {
-		dst.Upload.AlistPath = src.Upload.AlistPath
-	}
-	if src.Upload.MaxConcurrent != 0 {
-		dst.Upload.MaxConcurrent = src.Upload.MaxConcurrent
+	if camera.Username != "" || camera.Password != "" {
+		u.User = url.UserPassword(camera.Username, camera.Password)
 	}
+	return u.String()
 }
 
-func NewRecorder(config *Config, startTime, endTime time.Time) *Recorder {
-	rtspURL := fmt.Sprintf("rtsp://%s:%s@%s:%s/%s",
-		config.Camera.Username,
-		config.Camera.Password,
-		config.Camera.IP,
-		config.Camera.Port,
-		config.Camera.Stream)
-
-	return &Recorder{
-		rtspURL:     rtspURL,
-		outputDir:   config.Recording.OutputDir,
-		segmentTime: config.Recording.SegmentTime,
-		stopChan:    make(chan struct{}),
-		startChan:   make(chan struct{}),
-		sequence:    0,
-		isWindows:   runtime.GOOS == "windows",
-		startTime:   startTime,
-		endTime:     endTime,
-		retryCount:  0,
-		isRecording: false,
-		uploader:    NewFileUploader(&config.Upload),
-	}
+func NewRecorder(config *Config, camera CameraConfig, schedule *Schedule, queue *UploadQueue) *Recorder {
+	r := &Recorder{
+		name:             camera.Name,
+		logger:           slog.With("camera", camera.Name),
+		rtspURL:          cameraURL(camera),
+		outputDir:        filepath.Join(config.Recording.OutputDir, camera.OutputDir),
+		segmentTime:      config.Recording.SegmentTime,
+		segmentNaming:    config.Recording.SegmentNaming,
+		stopTimeout:      time.Duration(config.Recording.StopTimeout) * time.Second,
+		reconnectBase:    time.Duration(config.Recording.ReconnectDelay) * time.Second,
+		reconnectMax:     time.Duration(config.Recording.ReconnectMaxDelay) * time.Second,
+		offlineThreshold: config.Recording.OfflineThreshold,
+		probe:            NewRTSPProbe(camera),
+		stopChan:         make(chan struct{}),
+		sequence:         0,
+		isWindows:        runtime.GOOS == "windows",
+		clock:            systemClock{},
+		retryCount:       0,
+		isRecording:      false,
+		uploader:         queue.uploader,
+		queue:            queue,
+	}
+	if config.Recording.RTSPAuthProxy {
+		r.authProxy = NewRTSPAuthProxy(camera, r.logger)
+	}
+	r.schedule.Store(schedule)
+	return r
 }
 
+// startFFmpeg 启动 ffmpeg 录制进程，调用方需持有锁
 func (r *Recorder) startFFmpeg() error {
 	absOutputDir, err := filepath.Abs(r.outputDir)
 	if err != nil {
 		return fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
-	outputPattern := filepath.Join(absOutputDir, "segment_%03d.mkv")
+	outputPattern := filepath.Join(absOutputDir, r.segmentPattern())
 	if r.isWindows {
 		outputPattern = strings.ReplaceAll(outputPattern, "\\", "/")
 	}
 
+	// 使用认证代理时 ffmpeg 连接本地地址，只携带代理的随机凭据
+	input := r.rtspURL
+	if r.authProxy != nil {
+		if input, err = r.authProxy.Start(); err != nil {
+			return fmt.Errorf("failed to start RTSP auth proxy: %v", err)
+		}
+	}
+
 	args := []string{
+		"-hide_banner", "-nostats",
+		"-loglevel", "level+info", // 每行输出带级别标记，用于映射日志级别
 		"-rtsp_transport", "tcp",
 		"-timeout", "5000000", // 设置超时时间为5秒
-		"-i", r.rtspURL,
+		"-i", input,
 		"-c", "copy",
 		"-f", "segment",
 		"-segment_time", fmt.Sprintf("%d", r.segmentTime),
 		"-segment_format", "matroska",
 		"-reset_timestamps", "1",
 		"-fflags", "+genpts",
-		outputPattern,
 	}
+	if r.segmentNaming == SegmentNamingTimestamp {
+		// 文件名使用片段开始时的本地时间
+		args = append(args, "-strftime", "1")
+	} else {
+		// 从未使用的序号继续编号，避免重连后覆盖之前的片段
+		r.sequence = r.nextSequence(absOutputDir)
+		args = append(args, "-segment_start_number", fmt.Sprintf("%d", r.sequence))
+		r.logger.Info("Starting segment numbering", "segment", fmt.Sprintf("segment_%03d.mkv", r.sequence))
+	}
+	args = append(args, outputPattern)
 
+	// ffmpeg 的输出按行写入日志
+	output := newFFmpegLogWriter(r.logger)
 	cmd := exec.Command("ffmpeg", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = output
+	cmd.Stderr = output
 	cmd.Dir = absOutputDir
+	// 文件名中的时间与录制计划使用相同的时区
+	if location := r.schedule.Load().Location(); location != time.Local {
+		cmd.Env = append(os.Environ(), "TZ="+location.String())
+	}
+
+	// 保留标准输入，停止时写入 "q" 让 ffmpeg 写完当前片段的索引后退出
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start recording: %v", err)
 	}
 
+	// 在后台等待进程退出，退出后关闭 cmdDone
+	cmdDone := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		output.Flush()
+		r.mu.Lock()
+		r.cmdErr = err
+		r.mu.Unlock()
+		close(cmdDone)
+	}()
+
 	r.currentCmd = cmd
+	r.cmdStarted = time.Now()
+	r.cmdStdin = stdin
+	r.cmdDone = cmdDone
+	r.cmdErr = nil
 	return nil
 }
 
-func (r *Recorder) mergeSegments() (error, string) {
+// mergeSegments 使用 concat 将已完成的片段合并为 outputName，原始片段不会被删除
+func (r *Recorder) mergeSegments(segments []string, outputName string) (error, string) {
 	absOutputDir, err := filepath.Abs(r.outputDir)
 	if err != nil {
 		return fmt.Errorf("failed to get absolute path: %v", err), ""
 	}
 
-	time.Sleep(5 * time.Second)
-
-	// 强制结束所有 ffmpeg 进程
-	if r.isWindows {
-		exec.Command("taskkill", "/F", "/IM", "ffmpeg.exe").Run()
-	} else {
-		exec.Command("pkill", "-9", "ffmpeg").Run()
-	}
-	time.Sleep(5 * time.Second)
-
-	files, err := os.ReadDir(absOutputDir)
-	if err != nil {
-		return fmt.Errorf("failed to read directory: %v", err), ""
+	validSegments := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		validSegments = append(validSegments, filepath.Base(segment))
 	}
 
-	var validSegments []string
-	var invalidSegments []string
-	segmentCount := 0
-
-	// 首先统计所有片段文件
-	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "segment_") && strings.HasSuffix(file.Name(), ".mkv") {
-			segmentCount++
-		}
-	}
-
-	fmt.Printf("Found %d total segment files\n", segmentCount)
-
-	// 收集有效和无效的片段
-	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "segment_") && strings.HasSuffix(file.Name(), ".mkv") {
-			filePath := filepath.Join(absOutputDir, file.Name())
-			info, err := os.Stat(filePath)
-			if err != nil || info.Size() < 1024 {
-				invalidSegments = append(invalidSegments, file.Name())
-				// 删除无效的分片文件
-				if err := os.Remove(filePath); err != nil {
-					log.Printf("Warning: failed to remove invalid segment file %s: %v", filePath, err)
-				}
-				continue
-			}
-			validSegments = append(validSegments, file.Name())
-		}
-	}
-
-	fmt.Printf("Found %d valid segments and %d invalid segments\n", len(validSegments), len(invalidSegments))
+	r.logger.Info("Merging segments", "count", len(validSegments), "output", outputName)
 
 	if len(validSegments) == 0 {
 		return fmt.Errorf("no valid segments found to merge"), ""
 	}
 
-	// 对片段进行数字排序
+	// 按片段文件名中的时间或序号排序
 	sort.Slice(validSegments, func(i, j int) bool {
-		// 从文件名中提取数字
-		numI := strings.TrimPrefix(strings.TrimSuffix(validSegments[i], ".mkv"), "segment_")
-		numJ := strings.TrimPrefix(strings.TrimSuffix(validSegments[j], ".mkv"), "segment_")
-		// 将数字字符串转换为整数进行比较
-		iNum, _ := strconv.Atoi(numI)
-		jNum, _ := strconv.Atoi(numJ)
-		return iNum < jNum
+		return r.segmentLess(validSegments[i], validSegments[j])
 	})
 
 	// 创建合并列表文件
@@ -357,7 +360,7 @@ func (r *Recorder) mergeSegments() (error, string) {
 		segmentPath := filepath.Join(absOutputDir, segment)
 		// 再次验证文件是否存在
 		if _, err := os.Stat(segmentPath); err != nil {
-			log.Printf("Warning: segment file %s no longer exists, skipping", segmentPath)
+			r.logger.Warn("Segment file no longer exists, skipping", "segment", segment)
 			continue
 		}
 		if r.isWindows {
@@ -367,8 +370,6 @@ func (r *Recorder) mergeSegments() (error, string) {
 		}
 	}
 
-	fmt.Printf("Writing concat list with %d segments\n", len(validSegments))
-
 	if content == "" {
 		return fmt.Errorf("no valid segments available for merging"), ""
 	}
@@ -378,51 +379,53 @@ func (r *Recorder) mergeSegments() (error, string) {
 	}
 
 	// 输出concat_list.txt的内容以供验证
-	fmt.Println("Contents of concat_list.txt:")
-	fmt.Println(content)
+	r.logger.Debug("Wrote concat list", "path", listFile, "content", content)
 
 	// 设置输出文件路径
-	now := time.Now()
-	dateStr := now.Format("20060102") // 格式化日期为 YYYYMMDD
-	outputFile := filepath.Join(absOutputDir, fmt.Sprintf("merged_%s.mkv", dateStr))
-	r.sequence++
+	outputFile := filepath.Join(absOutputDir, outputName)
 
 	// 尝试合并，最多重试3次
 	maxRetries := 3
 	var mergeSuccess bool
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		fmt.Printf("Attempting to merge segments (attempt %d/%d)...\n", attempt, maxRetries)
+		r.logger.Debug("Attempting to merge segments", "attempt", attempt, "max_attempts", maxRetries)
 
 		args := []string{
+			"-hide_banner", "-nostats",
+			"-loglevel", "level+info",
 			"-f", "concat",
 			"-safe", "0",
 			"-i", listFile,
 			"-c", "copy",
+			"-y", // 覆盖上次失败留下的文件，避免 ffmpeg 等待确认
 			outputFile,
 		}
 
+		output := newFFmpegLogWriter(r.logger.With("output", outputName))
 		cmd := exec.Command("ffmpeg", args...)
 		cmd.Dir = absOutputDir
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = output
+		cmd.Stderr = output
 
-		if err := cmd.Run(); err != nil {
-			fmt.Printf("Merge attempt %d failed: %v\n", attempt, err)
+		err := cmd.Run()
+		output.Flush()
+		if err != nil {
+			r.logger.Warn("Merge attempt failed", "attempt", attempt, "error", err)
 			if attempt < maxRetries {
-				fmt.Println("Waiting 5 seconds before retry...")
+				r.logger.Info("Waiting 5 seconds before retry")
 				time.Sleep(5 * time.Second)
 				continue
 			}
 			// 删除可能存在的不完整输出文件
 			os.Remove(outputFile)
-			return fmt.Errorf("all merge attempts failed: %v"), ""
+			return fmt.Errorf("all merge attempts failed: %v", err), ""
 		}
 
 		// 验证输出文件
 		if info, err := os.Stat(outputFile); err != nil || info.Size() < 1024 {
-			fmt.Printf("Output file verification failed: %v\n", err)
+			r.logger.Warn("Output file verification failed", "attempt", attempt, "error", err)
 			if attempt < maxRetries {
-				fmt.Println("Waiting 5 seconds before retry...")
+				r.logger.Info("Waiting 5 seconds before retry")
 				time.Sleep(5 * time.Second)
 				continue
 			}
@@ -439,74 +442,72 @@ func (r *Recorder) mergeSegments() (error, string) {
 		return fmt.Errorf("merge failed after %d attempts", maxRetries), ""
 	}
 
-	// 合并成功后，删除原始分片文件
-	fmt.Println("Merge successful, cleaning up segment files...")
-	for _, segment := range validSegments {
-		segmentPath := filepath.Join(absOutputDir, segment)
-		// 先检查文件是否存在
-		if _, err := os.Stat(segmentPath); err != nil {
-			continue // 文件不存在，跳过
-		}
-
-		for i := 0; i < 10; i++ {
-			if r.isWindows {
-				exec.Command("taskkill", "/F", "/IM", "ffmpeg.exe").Run()
-			} else {
-				exec.Command("pkill", "-9", "ffmpeg").Run()
-			}
-			time.Sleep(1 * time.Second)
-
-			err := os.Remove(segmentPath)
-			if err == nil {
-				break
-			}
-			if err != nil && strings.Contains(err.Error(), "being used by another process") {
-				time.Sleep(2 * time.Second)
-				continue
-			}
-			log.Printf("Warning: failed to remove segment file %s: %v", segmentPath, err)
-			break
-		}
-	}
+	// 原始片段保留到合并文件上传成功后，由上传队列删除
 
 	// 删除 concat_list.txt 文件
 	if err := os.Remove(listFile); err != nil {
-		log.Printf("Warning: failed to remove concat list file: %v", err)
+		r.logger.Warn("Failed to remove concat list file", "error", err)
 	} else {
-		fmt.Printf("Successfully removed concat list file: %s\n", listFile)
+		r.logger.Debug("Removed concat list file", "path", listFile)
 	}
 
-	fmt.Printf("Successfully merged %d segments into %s\n", len(validSegments), outputFile)
+	r.logger.Info("Merged segments", "count", len(validSegments), "output", outputFile)
 
 	return nil, outputFile
 }
 
+// stopFFmpeg 让 ffmpeg 正常退出，超时后只强制结束本录制器启动的进程
 func (r *Recorder) stopFFmpeg() error {
-	time.Sleep(5 * time.Second)
-	if r.currentCmd != nil && r.currentCmd.Process != nil {
-		done := make(chan error)
-		go func() {
-			done <- r.currentCmd.Wait()
-		}()
+	r.mu.Lock()
+	cmd, stdin, cmdDone := r.currentCmd, r.cmdStdin, r.cmdDone
+	r.mu.Unlock()
 
-		if r.isWindows {
-			exec.Command("taskkill", "/F", "/T", "/PID", fmt.Sprintf("%d", r.currentCmd.Process.Pid)).Run()
-		} else {
-			exec.Command("kill", "-9", fmt.Sprintf("%d", r.currentCmd.Process.Pid)).Run()
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	select {
+	case <-cmdDone:
+		return nil
+	default:
+	}
+
+	// 优先通过标准输入发送 "q"，失败时发送 SIGINT（Windows 不支持）
+	if _, err := io.WriteString(stdin, "q"); err != nil {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			r.logger.Warn("Failed to interrupt ffmpeg", "error", err)
 		}
+	}
 
-		select {
-		case err := <-done:
-			if err != nil && !strings.Contains(err.Error(), "signal: killed") {
-				return fmt.Errorf("process exited with error: %v", err)
-			}
-		case <-time.After(5 * time.Second):
-			return fmt.Errorf("timeout waiting for process to exit")
+	timeout := r.stopTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	select {
+	case <-cmdDone:
+		r.mu.Lock()
+		err := r.cmdErr
+		r.currentCmd = nil
+		r.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("process exited with error: %v", err)
 		}
+		return nil
+	case <-time.After(timeout):
+	}
 
+	r.logger.Warn("ffmpeg did not exit in time, killing it", "timeout", timeout.String(), "pid", cmd.Process.Pid)
+	if err := cmd.Process.Kill(); err != nil {
+		return fmt.Errorf("failed to kill process: %v", err)
+	}
+	select {
+	case <-cmdDone:
+		r.mu.Lock()
 		r.currentCmd = nil
+		r.mu.Unlock()
+		return fmt.Errorf("process killed after %v timeout", timeout)
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("timeout waiting for process to exit")
 	}
-	return nil
 }
 
 func (r *Recorder) IsRecording() bool {
@@ -515,301 +516,393 @@ func (r *Recorder) IsRecording() bool {
 	return r.isRecording
 }
 
+// Start 开始录制，可以在停止后再次调用
 func (r *Recorder) Start() {
 	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.isRecording {
-		r.mu.Unlock()
 		return
 	}
 	r.isRecording = true
-	r.mu.Unlock()
-	close(r.startChan)
+	r.sessionDate = r.now().Format("20060102")
+	r.stopChan = make(chan struct{})
+	r.recordingDone = make(chan struct{})
+	notifier.Notify(EventRecordingStarted, r.name, "Recording started (session %s)", r.sessionDate)
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		if err := r.StartRecording(stop); err != nil {
+			r.logger.Error("Recording failed", "error", err)
+		}
+		close(done)
+	}(r.stopChan, r.recordingDone)
 }
 
-func (r *Recorder) StartRecording() error {
+// SessionDate 返回本次录制的日期
+func (r *Recorder) SessionDate() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessionDate == "" {
+		return r.now().Format("20060102")
+	}
+	return r.sessionDate
+}
+
+// now 返回录制计划所在时区的当前时间
+func (r *Recorder) now() time.Time {
+	return r.clock.Now().In(r.schedule.Load().Location())
+}
+
+// StartRecording 循环运行 ffmpeg，进程异常退出时自动重连，直到 stop 被关闭
+func (r *Recorder) StartRecording(stop <-chan struct{}) error {
 	if err := os.MkdirAll(r.outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %v", err)
 	}
 
-	// 等待开始信号
-	<-r.startChan
+	// 录制期间持续上传已完成的片段
+	watchDone := make(chan struct{})
+	defer close(watchDone)
+	go r.watchSegments(watchDone)
 
 	for {
-		now := time.Now()
-		if now.After(r.endTime) {
-			fmt.Printf("Reached end time %s, stopping recording...\n", r.endTime.Format("15:04:05"))
-			if r.currentCmd != nil && r.currentCmd.Process != nil {
-				if err := r.stopFFmpeg(); err != nil {
-					fmt.Printf("Warning: failed to stop ffmpeg process: %v\n", err)
-				}
-			}
-			time.Sleep(5 * time.Second)
+		// 启动 ffmpeg 前先确认摄像头可以连接、认证并找到码流
+		probeErr := r.probe.Probe()
 
-			// 不在这里合并片段
-			r.mu.Lock()
-			r.isRecording = false
+		// 持有锁检查停止信号并启动 ffmpeg，保证 Stop 之后不会再启动新的进程
+		r.mu.Lock()
+		select {
+		case <-stop:
 			r.mu.Unlock()
 			return nil
+		default:
+		}
+		r.probeErr = probeErr
+		var err error
+		if probeErr != nil {
+			r.recordFailure()
+		} else if err = r.startFFmpeg(); err != nil {
+			r.recordFailure()
+			metrics.Inc(metricFFmpegRestarts, "camera", r.name)
 		}
+		cmdDone := r.cmdDone
+		r.mu.Unlock()
 
-		select {
-		case <-r.stopChan:
-			if r.currentCmd != nil && r.currentCmd.Process != nil {
-				if err := r.stopFFmpeg(); err != nil {
-					fmt.Printf("Warning: failed to stop ffmpeg process: %v\n", err)
-				}
+		if probeErr != nil {
+			r.logger.Warn("Camera probe failed", "error", probeErr, "error_type", probeErrorType(probeErr))
+		} else if err != nil {
+			r.logger.Error("Failed to start ffmpeg", "error", err)
+		} else {
+			r.logger.Info("ffmpeg started, connecting to camera")
+			<-cmdDone
+			select {
+			case <-stop:
+				return nil
+			default:
 			}
-			time.Sleep(5 * time.Second)
 
-			// 不在这里合并片段
+			// 本次运行产生了片段说明摄像头在线，否则计为一次连续失败
 			r.mu.Lock()
-			r.isRecording = false
+			err, started := r.cmdErr, r.cmdStarted
 			r.mu.Unlock()
-			return nil
-		default:
-			if err := r.startFFmpeg(); err != nil {
-				r.retryCount++
-				fmt.Printf("Error starting ffmpeg (attempt %d): %v\n", r.retryCount, err)
-				time.Sleep(5 * time.Second)
-				continue
+			if r.segmentProducedSince(started) {
+				r.markOnline()
+			} else {
+				r.mu.Lock()
+				r.recordFailure()
+				r.mu.Unlock()
 			}
-
-			// 重置重试计数
-			r.retryCount = 0
-			fmt.Println("Successfully connected to camera")
-
-			if err := r.currentCmd.Wait(); err != nil {
-				r.retryCount++
-				fmt.Printf("Warning: ffmpeg process exited with error (attempt %d): %v\n", r.retryCount, err)
-				time.Sleep(5 * time.Second)
-				continue
+			metrics.Inc(metricFFmpegRestarts, "camera", r.name)
+			if err != nil {
+				r.logger.Warn("ffmpeg process exited with error", "error", err)
 			}
+		}
 
-			time.Sleep(5 * time.Second)
+		r.mu.Lock()
+		failures := r.retryCount
+		r.mu.Unlock()
+		delay := r.reconnectDelay(failures)
+		r.logger.Info("Reconnecting", "delay", delay.Round(time.Second).String(), "consecutive_failures", failures)
+		if !sleepOrStop(stop, delay) {
+			return nil
 		}
 	}
 }
 
+// sleepOrStop 等待指定时间，期间收到停止信号时返回 false
+func sleepOrStop(stop <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Stop 停止录制，并在后台补充上传录制期间未上传的片段
 func (r *Recorder) Stop() {
 	r.mu.Lock()
+	if r.stopping {
+		// 另一个 Stop 正在进行（如 HTTP 接口触发的停止），等待其完成，避免退出时 ffmpeg 还未写完片段
+		stopDone := r.stopDone
+		r.mu.Unlock()
+		<-stopDone
+		return
+	}
 	if !r.isRecording {
 		r.mu.Unlock()
 		return
 	}
+	r.stopping = true
+	r.stopDone = make(chan struct{})
+	stopDone := r.stopDone
+	close(r.stopChan)
+	recordingDone := r.recordingDone
 	r.mu.Unlock()
 
-	close(r.stopChan)
 	// 等待录制完全停止
-	if r.currentCmd != nil && r.currentCmd.Process != nil {
-		if err := r.stopFFmpeg(); err != nil {
-			fmt.Printf("Warning: failed to stop ffmpeg process: %v\n", err)
-		}
+	if err := r.stopFFmpeg(); err != nil {
+		r.logger.Warn("Failed to stop ffmpeg process", "error", err)
 	}
-	time.Sleep(5 * time.Second)
+	<-recordingDone
 
-	// 获取录制结束时的日期
-	recordingEndDate := time.Now().Format("20060102")
-	fmt.Printf("Recording ended at %s, using this date for all uploads\n", recordingEndDate)
+	// 使用录制开始时的日期作为上传目录，与录制期间上传的片段保持一致
+	recordingDate := r.SessionDate()
+	r.logger.Info("Recording ended, uploading remaining segments", "session", recordingDate)
+	notifier.Notify(EventRecordingStopped, r.name, "Recording stopped (session %s)", recordingDate)
 
-	// 在新的 goroutine 中处理上传
+	// 补充录制期间未上传的片段（包括最后一个片段），按上传模式合并，然后在后台等待上传结果
+	srcPaths, err := r.enqueueCompletedSegments(true)
+	if err != nil {
+		r.logger.Error("Failed to scan segments", "error", err)
+	}
+	if r.uploader.Config().Mode != UploadModeSegments {
+		srcPaths = append(srcPaths, r.mergeCompletedGroups(true)...)
+	}
+	if len(srcPaths) == 0 {
+		r.logger.Info("No valid segments to upload")
+	}
 	go func() {
-		// 获取录制目录的绝对路径
-		absOutputDir, err := filepath.Abs(r.outputDir)
-		if err != nil {
-			fmt.Printf("Error getting absolute path: %v\n", err)
+		if len(srcPaths) == 0 {
 			return
 		}
 
-		// 获取所有分段文件
-		files, err := os.ReadDir(absOutputDir)
-		if err != nil {
-			fmt.Printf("Error reading directory: %v\n", err)
-			return
-		}
+		// 等待本次录制的上传完成首轮尝试，失败的任务会留在队列中继续重试
+		r.logger.Info("Waiting for uploads to complete", "count", len(srcPaths))
+		uploaded := r.queue.WaitFor(srcPaths)
 
-		var validSegments []string
-		for _, file := range files {
-			if strings.HasPrefix(file.Name(), "segment_") && strings.HasSuffix(file.Name(), ".mkv") {
-				filePath := filepath.Join(absOutputDir, file.Name())
-				info, err := os.Stat(filePath)
-				if err != nil || info.Size() < 1024 {
-					// 删除无效的分片文件
-					if err := os.Remove(filePath); err != nil {
-						log.Printf("Warning: failed to remove invalid segment file %s: %v", filePath, err)
-					}
-					continue
-				}
-				validSegments = append(validSegments, file.Name())
-			}
-		}
+		// 打印上传统计
+		r.logger.Info("Upload summary", "uploaded", uploaded, "total", len(srcPaths))
+		notifier.Notify(EventUploadSummary, r.name, "%d/%d files successfully uploaded", uploaded, len(srcPaths))
+	}()
 
-		// 按文件名排序
-		sort.Slice(validSegments, func(i, j int) bool {
-			numI := strings.TrimPrefix(strings.TrimSuffix(validSegments[i], ".mkv"), "segment_")
-			numJ := strings.TrimPrefix(strings.TrimSuffix(validSegments[j], ".mkv"), "segment_")
-			iNum, _ := strconv.Atoi(numI)
-			jNum, _ := strconv.Atoi(numJ)
-			return iNum < jNum
-		})
+	// 立即设置状态为 false，不等待上传完成
+	r.mu.Lock()
+	r.isRecording = false
+	r.stopping = false
+	r.mu.Unlock()
+	close(stopDone)
+}
 
-		fmt.Printf("Found %d valid segments to upload\n", len(validSegments))
+// SetSchedule 更新录制计划，正在录制时等到本次录制结束后生效
+func (r *Recorder) SetSchedule(schedule *Schedule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pendingSchedule = schedule
+}
 
-		if len(validSegments) == 0 {
-			fmt.Println("No valid segments to upload")
-			return
-		}
+// applyPendingSchedule 不在录制时启用等待生效的录制计划，返回启用的录制计划
+func (r *Recorder) applyPendingSchedule() *Schedule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pendingSchedule == nil || r.isRecording {
+		return nil
+	}
+	schedule := r.pendingSchedule
+	r.pendingSchedule = nil
+	r.schedule.Store(schedule)
+	return schedule
+}
 
-		// 创建任务通道和等待组
-		tasks := make(chan string, len(validSegments))
-		var wg sync.WaitGroup
+// Window 返回当前或下一个录制窗口
+func (r *Recorder) Window() (time.Time, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.startTime, r.endTime
+}
 
-		// 创建上传状态管理
-		type uploadStatus struct {
-			sync.Mutex
-			inProgress map[string]bool
-			completed  map[string]bool
-		}
-		status := &uploadStatus{
-			inProgress: make(map[string]bool),
-			completed:  make(map[string]bool),
-		}
+// setWindow 更新当前或下一个录制窗口
+func (r *Recorder) setWindow(start, end time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startTime, r.endTime = start, end
+}
 
-		// 启动工作协程
-		maxWorkers := r.uploader.config.MaxConcurrent
-		if maxWorkers <= 0 {
-			maxWorkers = 3 // 默认值
+// Run 按照录制计划循环启动和停止录制，ctx 取消时停止录制并返回
+func (r *Recorder) Run(ctx context.Context) {
+	if r.authProxy != nil {
+		defer r.authProxy.Close()
+	}
+	r.logger.Info("Waiting for recording period", "schedule", r.schedule.Load().String())
+	scheduler := NewScheduler(r.schedule.Load())
+	for {
+		// 重新加载的录制计划在不录制时生效，仍处于同一个窗口时不会重新开始录制
+		if schedule := r.applyPendingSchedule(); schedule != nil {
+			next := NewScheduler(schedule)
+			next.activeStart = scheduler.activeStart
+			scheduler = next
+			r.logger.Info("Applied new schedule", "schedule", schedule.String())
 		}
 
-		fmt.Printf("Starting %d upload workers\n", maxWorkers)
-
-		// 创建工作协程
-		for i := 0; i < maxWorkers; i++ {
-			wg.Add(1)
-			go func(workerID int) {
-				defer wg.Done()
-				for segment := range tasks {
-					// 检查文件是否已经在上传或已完成
-					status.Lock()
-					if status.inProgress[segment] || status.completed[segment] {
-						status.Unlock()
-						continue
-					}
-					status.inProgress[segment] = true
-					status.Unlock()
-
-					segmentPath := filepath.Join(absOutputDir, segment)
-					destPath := filepath.Join(r.uploader.config.AlistPath, recordingEndDate, segment)
-
-					fmt.Printf("[Worker %d] Uploading segment: %s to %s\n", workerID, segment, destPath)
-
-					// 尝试上传文件
-					var uploadErr error
-					var uploadSuccess bool
-					for i := 0; i < r.uploader.config.RetryCount; i++ {
-						if response, err := r.uploader.UploadFile(segmentPath, destPath, recordingEndDate); err != nil {
-							uploadErr = err
-							log.Printf("[Worker %d] Upload attempt %d/%d failed for %s: %v",
-								workerID, i+1, r.uploader.config.RetryCount, segment, err)
-							time.Sleep(time.Duration(r.uploader.config.RetryDelay) * time.Second)
-							continue
-						} else {
-							responseJSON, _ := json.MarshalIndent(response, "", "  ")
-							fmt.Printf("[Worker %d] Upload response for %s: %s\n", workerID, segment, string(responseJSON))
-							uploadErr = nil
-							uploadSuccess = true
-							break
-						}
-					}
-
-					// 更新上传状态
-					status.Lock()
-					delete(status.inProgress, segment)
-					if uploadSuccess {
-						status.completed[segment] = true
-					}
-					status.Unlock()
-
-					if uploadErr != nil {
-						log.Printf("[Worker %d] Failed to upload segment %s after %d attempts: %v",
-							workerID, segment, r.uploader.config.RetryCount, uploadErr)
-					}
-				}
-				fmt.Printf("[Worker %d] Finished processing all assigned segments\n", workerID)
-			}(i)
+		now := r.now()
+		action, start, end := scheduler.Tick(now)
+		if !start.IsZero() {
+			r.setWindow(start, end)
 		}
 
-		// 发送任务到通道
-		fmt.Printf("Queueing %d segments for upload\n", len(validSegments))
-		for _, segment := range validSegments {
-			tasks <- segment
+		switch action {
+		case ScheduleStart:
+			// 开始逻辑：进入新的窗口时如果未在录制，则开始录制
+			if !r.IsRecording() {
+				r.logger.Info("Within recording period, starting recording", "until", end.Format("01-02 15:04"))
+				r.Start()
+			}
+		case ScheduleStop:
+			// 终止逻辑：离开窗口时如果正在录制，则停止录制
+			if r.IsRecording() {
+				r.logger.Info("Recording period ended, stopping recording")
+				r.Stop()
+			}
+			r.logger.Info("Waiting for recording period", "next_start", start.Format("01-02 15:04"))
 		}
-		close(tasks)
-
-		// 等待所有上传完成
-		fmt.Println("Waiting for all uploads to complete...")
-		wg.Wait()
 
-		// 打印上传统计
-		status.Lock()
-		fmt.Printf("Upload summary: %d/%d files successfully uploaded\n",
-			len(status.completed), len(validSegments))
-		status.Unlock()
-
-		fmt.Println("All uploads completed")
-	}()
-
-	// 立即设置状态为 false，不等待上传完成
-	r.mu.Lock()
-	r.isRecording = false
-	r.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			if r.IsRecording() {
+				r.logger.Info("Shutting down, stopping recording")
+				r.Stop()
+			}
+			return
+		case <-r.clock.After(1 * time.Second):
+		}
+	}
 }
 
 func main() {
-	fmt.Println("Version: 0.1")
-	config, err := loadConfig()
+	// 第一个参数不是选项时作为子命令，目前只支持 dump-config
+	args, command := os.Args[1:], ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("autoUpdateCam", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: autoUpdateCam [dump-config] [options]\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", "", "path to config file (.json, .yaml, .yml or .toml), defaults to $CONFIG_FILE or config.json")
+	var overrides stringList
+	flags.Var(&overrides, "set", "override a config key, e.g. --set recording.start_hour=0 (repeatable, highest precedence)")
+	flags.Parse(args)
+
+	// 启动失败时以非零状态退出，使 Docker 的重启策略和 systemd 能识别为失败
+	config, err := loadConfig(*configFile, overrides)
 	if err != nil {
-		fmt.Printf("Error loading config: %v\n", err)
+		slog.Error("Error loading config", "error", err)
+		os.Exit(1)
+	}
+	setLogSecrets(config.secrets())
+	switch command {
+	case "":
+	case "dump-config":
+		// 输出实际生效的配置及其来源后退出
+		config.Dump(os.Stdout)
 		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+		flags.Usage()
+		os.Exit(2)
 	}
-	now := time.Now()
-	startTime := time.Date(now.Year(), now.Month(), now.Day(), config.Recording.StartHour, config.Recording.StartMinute, 0, 0, now.Location())
-	endTime := time.Date(now.Year(), now.Month(), now.Day(), config.Recording.EndHour, config.Recording.EndMinute, 0, 0, now.Location())
 
-	// 创建一个全局的录制器实例
-	recorder := NewRecorder(config, startTime, endTime)
-	recordingDone := make(chan struct{})
+	// 收到 SIGINT/SIGTERM（如 docker stop）时停止录制并保存上传队列后退出
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
-	// 启动录制逻辑的 goroutine
-	go func() {
-		if err := recorder.StartRecording(); err != nil {
-			fmt.Printf("Error: %v\n", err)
+	if err := setupLogger(&config.Log); err != nil {
+		slog.Error("Error loading config", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Starting autoUpdateCam", "version", "0.1")
+	cameras, err := config.CameraList()
+	if err != nil {
+		slog.Error("Error loading config", "error", err)
+		os.Exit(1)
+	}
+	config.logSummary(cameras)
+
+	// 所有摄像头共享一个持久化上传队列，启动时继续上传上次未完成的文件
+	uploader, err := NewFileUploader(&config.Upload)
+	if err != nil {
+		slog.Error("Error creating uploader", "error", err)
+		os.Exit(1)
+	}
+	notifier, err = NewNotifier(&config.Notify)
+	if err != nil {
+		slog.Error("Error creating notifier", "error", err)
+		os.Exit(1)
+	}
+	queue, err := NewUploadQueue(config.Recording.OutputDir, uploader)
+	if err != nil {
+		slog.Error("Error loading upload queue", "error", err)
+		os.Exit(1)
+	}
+	queue.Start(config.Upload.MaxConcurrent)
+
+	// 为每个摄像头创建独立的录制器
+	supervisor := NewSupervisor(ctx, queue)
+	if err := supervisor.Apply(config, cameras); err != nil {
+		slog.Error("Error loading config", "error", err)
+		os.Exit(1)
+	}
+
+	// 配置文件修改或收到 SIGHUP 时重新加载配置，加载失败时继续使用当前配置
+	current := config
+	reload := func() {
+		newConfig, err := loadConfig(*configFile, overrides)
+		if err == nil {
+			err = reloadConfig(current, newConfig, uploader, supervisor)
 		}
-		close(recordingDone)
+		if err != nil {
+			slog.Error("Error reloading config, keeping current config", "error", err)
+			return
+		}
+		current = newConfig
+		slog.Info("Config reloaded")
+	}
+	watchPath, _ := configFilePath(*configFile)
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		watchConfig(ctx, watchPath, reload)
 	}()
-	println("start success! Waiting for recording period...")
-	flag := false
-	for {
-		now := time.Now()
-		if now.After(startTime) && now.Before(endTime) {
-			// 开始逻辑：如果未在录制，则开始录制
-			if !recorder.IsRecording() && !flag {
-				flag = true
-				fmt.Printf("Current time %s is within recording period, starting recording...\n", now.Format("15:04:05"))
-				recorder.Start()
-			}
-		} else if now.After(endTime) {
-			// 终止逻辑：如果正在录制，则停止录制
-			if recorder.IsRecording() && flag {
-				flag = false
-				fmt.Printf("Reached end time %s, stopping recording...\n", endTime.Format("15:04:05"))
-				recorder.Stop()
-				println("Waiting for recording period...")
-				<-recordingDone // 等待录制完全停止
-				// 重置开始和结束时间到下一天
-				startTime = startTime.Add(24 * time.Hour)
-				endTime = endTime.Add(24 * time.Hour)
+
+	// 启动 HTTP 状态和控制接口
+	if config.HTTP.Listen != "" {
+		api := NewAPIServer(supervisor, queue, config.HTTP.Token)
+		go func() {
+			if err := api.ListenAndServe(config.HTTP.Listen); err != nil {
+				slog.Warn("HTTP API stopped", "error", err)
 			}
-		}
-		time.Sleep(1 * time.Second)
+		}()
 	}
+	slog.Info("Started", "cameras", len(cameras))
+
+	<-ctx.Done()
+	stopSignals() // 再次收到信号时立即退出
+	slog.Info("Received shutdown signal, stopping recorders")
+	<-watchDone
+	supervisor.Wait()
+
+	shutdownWait := time.Duration(current.Upload.ShutdownWait) * time.Second
+	slog.Info("Waiting for uploads to finish", "timeout", shutdownWait.String())
+	queue.Shutdown(shutdownWait)
+	notifier.Close(notifyTimeout)
+	slog.Info("Shutdown complete")
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// segmentSpan 片段及其录制时间范围
+type segmentSpan struct {
+	path  string
+	start time.Time
+	end   time.Time
+}
+
+// segmentGroup 需要合并为一个文件的一组片段
+type segmentGroup struct {
+	name     string // 合并文件名（不含扩展名）
+	segments []string
+}
+
+// rollingMerge 是否在录制期间滚动合并（按时间段或片段数量）
+func (r *Recorder) rollingMerge() bool {
+	config := r.uploader.Config()
+	return config.MergeInterval > 0 || config.MergeSegments > 0
+}
+
+// segmentSpanOf 返回片段的录制时间范围，结束时间取文件修改时间，
+// 开始时间取文件名中的时间，序号命名的片段按片段时长推算
+func (r *Recorder) segmentSpanOf(filePath string) (segmentSpan, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return segmentSpan{}, err
+	}
+	end := info.ModTime().In(r.schedule.Load().Location())
+	start, ok := r.segmentTimestamp(filepath.Base(filePath))
+	if !ok {
+		start = end.Add(-time.Duration(r.segmentTime) * time.Second)
+	}
+	return segmentSpan{path: filePath, start: start, end: end}, nil
+}
+
+// mergeCompletedGroups 合并已完成的片段组并加入上传队列，返回加入队列的文件路径
+// final 为 false 时只合并已经完整的组（时间段已结束或片段数量已满），final 为 true 时合并所有剩余片段
+func (r *Recorder) mergeCompletedGroups(final bool) []string {
+	// 录制期间的滚动合并与停止时的合并可能同时发生，串行执行避免重复合并
+	r.mergeMu.Lock()
+	defer r.mergeMu.Unlock()
+
+	segments, err := r.completedSegments(final)
+	if err != nil {
+		r.logger.Error("Failed to scan segments", "error", err)
+		return nil
+	}
+
+	// 跳过已经合并、正在等待合并文件上传的片段
+	claimed := r.queue.Claimed()
+	var spans []segmentSpan
+	for _, segment := range segments {
+		if claimed[segment] {
+			continue
+		}
+		span, err := r.segmentSpanOf(segment)
+		if err != nil {
+			continue
+		}
+		spans = append(spans, span)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return r.segmentLess(spans[i].path, spans[j].path)
+	})
+
+	var srcPaths []string
+	for _, group := range r.groupSegments(spans, final) {
+		srcPaths = append(srcPaths, r.mergeGroup(group)...)
+	}
+	return srcPaths
+}
+
+// groupSegments 按配置将片段分组，未完整的组只在 final 为 true 时返回
+func (r *Recorder) groupSegments(spans []segmentSpan, final bool) []segmentGroup {
+	config := r.uploader.Config()
+	var groups []segmentGroup
+
+	switch {
+	case config.MergeInterval > 0:
+		// 按整点对齐的时间段分组，如每小时 08:00-09:00
+		// 正在写入的片段从最后一个已完成片段结束时开始，时间段结束后才认为该组完整
+		interval := time.Duration(config.MergeInterval) * time.Minute
+		cutoff := spans[len(spans)-1].end
+		for i := 0; i < len(spans); {
+			start := spans[i].start
+			midnight := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+			bucketStart := midnight.Add(start.Sub(midnight) / interval * interval)
+			bucketEnd := bucketStart.Add(interval)
+
+			var paths []string
+			for ; i < len(spans) && spans[i].start.Before(bucketEnd); i++ {
+				paths = append(paths, spans[i].path)
+			}
+			if !final && cutoff.Before(bucketEnd) {
+				break
+			}
+			groups = append(groups, segmentGroup{name: mergedRangeName(bucketStart, bucketEnd), segments: paths})
+		}
+
+	case config.MergeSegments > 0:
+		// 每 N 个片段合并一次
+		for i := 0; i < len(spans); i += config.MergeSegments {
+			j := i + config.MergeSegments
+			if j > len(spans) {
+				if !final {
+					break
+				}
+				j = len(spans)
+			}
+			paths := make([]string, 0, j-i)
+			for _, span := range spans[i:j] {
+				paths = append(paths, span.path)
+			}
+			groups = append(groups, segmentGroup{name: mergedRangeName(spans[i].start, spans[j-1].end), segments: paths})
+		}
+
+	default:
+		// 录制结束后合并为一个文件
+		if !final {
+			return nil
+		}
+		paths := make([]string, 0, len(spans))
+		for _, span := range spans {
+			paths = append(paths, span.path)
+		}
+		groups = append(groups, segmentGroup{name: fmt.Sprintf("merged_%s", r.SessionDate()), segments: paths})
+	}
+	return groups
+}
+
+// mergedRangeName 返回带时间范围的合并文件名，如 merged_20261016_0800-0900
+func mergedRangeName(start, end time.Time) string {
+	return fmt.Sprintf("merged_%s-%s", start.Format("20060102_1504"), end.Format("1504"))
+}
+
+// mergeGroup 合并一组片段并加入上传队列，返回加入队列的文件路径
+// 合并失败时 merged 模式改为逐个上传片段，both 模式在片段上传后删除本地文件
+func (r *Recorder) mergeGroup(group segmentGroup) []string {
+	outputName := r.mergedFileName(group.name)
+	err, mergedFile := r.mergeSegments(group.segments, outputName)
+	if err != nil {
+		r.logger.Error("Failed to merge segments", "output", outputName, "error", err)
+		if r.uploader.Config().Mode == UploadModeMerged {
+			r.enqueueSegments(group.segments, false)
+			return group.segments
+		}
+		r.queue.Release(r.name, group.segments)
+		return nil
+	}
+
+	// 原始片段在合并文件上传成功后删除
+	r.queue.Enqueue(UploadTask{
+		Camera:   r.name,
+		SrcPath:  mergedFile,
+		DestPath: path.Join(r.name, r.SessionDate(), filepath.Base(mergedFile)),
+		Keep:     r.uploader.Config().KeepLocal,
+		Cleanup:  group.segments,
+	})
+	r.logger.Info("Queued merged file", "segment", filepath.Base(mergedFile), "segments", len(group.segments))
+	return []string{mergedFile}
+}
+
+// mergedFileName 返回不与本地文件或近期上传冲突的合并文件名，同名时追加序号
+func (r *Recorder) mergedFileName(base string) string {
+	name := base + ".mkv"
+	for i := 2; ; i++ {
+		filePath := filepath.Join(r.outputDir, name)
+		if absPath, err := filepath.Abs(filePath); err == nil {
+			filePath = absPath
+		}
+		if _, err := os.Stat(filePath); err != nil && !r.queue.Has(filePath) {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d.mkv", base, i)
+	}
+}
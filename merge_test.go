@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newTestRecorder 创建不连接摄像头的录制器，片段保存在临时目录中，上传使用 fakeUploader
+func newTestRecorder(t *testing.T, config *UploadConfig) (*Recorder, *fakeUploader) {
+	t.Helper()
+	dir := t.TempDir()
+	backend := &fakeUploader{}
+	queue, err := NewUploadQueue(dir, newTestUploader(config, backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Recorder{
+		name:          "cam1",
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		outputDir:     dir,
+		segmentTime:   600,
+		segmentNaming: SegmentNamingTimestamp,
+		clock:         &fakeClock{now: at(16, 12, 0)},
+		uploader:      queue.uploader,
+		queue:         queue,
+		sessionDate:   "20261016",
+	}
+	r.schedule.Store(mustSchedule(t, "08:00-18:00"))
+	return r, backend
+}
+
+// spansFrom 返回从 hour:minute 开始的 n 个连续 10 分钟片段
+func spansFrom(hour, minute, n int) []segmentSpan {
+	spans := make([]segmentSpan, n)
+	start := at(16, hour, minute)
+	for i := range spans {
+		spans[i] = segmentSpan{
+			path:  fmt.Sprintf("cam1_%s.mkv", start.Format(segmentTimeLayout)),
+			start: start,
+			end:   start.Add(10 * time.Minute),
+		}
+		start = spans[i].end
+	}
+	return spans
+}
+
+func TestGroupSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   UploadConfig
+		spans    []segmentSpan
+		final    bool
+		want     []string // 合并文件名
+		segments []int    // 每组的片段数量
+	}{
+		{
+			name:     "interval waits for bucket end",
+			config:   UploadConfig{MergeInterval: 60},
+			spans:    spansFrom(8, 0, 8), // 08:00-09:20
+			want:     []string{"merged_20261016_0800-0900"},
+			segments: []int{6},
+		},
+		{
+			name:     "interval final",
+			config:   UploadConfig{MergeInterval: 60},
+			spans:    spansFrom(8, 0, 8),
+			final:    true,
+			want:     []string{"merged_20261016_0800-0900", "merged_20261016_0900-1000"},
+			segments: []int{6, 2},
+		},
+		{
+			name:     "interval aligned to midnight",
+			config:   UploadConfig{MergeInterval: 30},
+			spans:    spansFrom(8, 20, 4), // 08:20-09:00
+			want:     []string{"merged_20261016_0800-0830", "merged_20261016_0830-0900"},
+			segments: []int{1, 3},
+		},
+		{
+			name:     "count",
+			config:   UploadConfig{MergeSegments: 3},
+			spans:    spansFrom(8, 0, 7),
+			want:     []string{"merged_20261016_0800-0830", "merged_20261016_0830-0900"},
+			segments: []int{3, 3},
+		},
+		{
+			name:     "count final",
+			config:   UploadConfig{MergeSegments: 3},
+			spans:    spansFrom(8, 0, 7),
+			final:    true,
+			want:     []string{"merged_20261016_0800-0830", "merged_20261016_0830-0900", "merged_20261016_0900-0910"},
+			segments: []int{3, 3, 1},
+		},
+		{
+			name:  "daily waits for final",
+			spans: spansFrom(8, 0, 3),
+		},
+		{
+			name:     "daily final",
+			spans:    spansFrom(8, 0, 3),
+			final:    true,
+			want:     []string{"merged_20261016"},
+			segments: []int{3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Mode = UploadModeMerged
+			r, _ := newTestRecorder(t, &config)
+			var names []string
+			var segments []int
+			for _, group := range r.groupSegments(tt.spans, tt.final) {
+				names = append(names, group.name)
+				segments = append(segments, len(group.segments))
+			}
+			if !reflect.DeepEqual(names, tt.want) || !reflect.DeepEqual(segments, tt.segments) {
+				t.Errorf("groupSegments() = %v %v, want %v %v", names, segments, tt.want, tt.segments)
+			}
+		})
+	}
+}
+
+func TestMergedFileName(t *testing.T) {
+	r, _ := newTestRecorder(t, &UploadConfig{Mode: UploadModeMerged})
+	base := "merged_20261016_0800-0900"
+	if got := r.mergedFileName(base); got != base+".mkv" {
+		t.Errorf("mergedFileName() = %s, want %s.mkv", got, base)
+	}
+
+	// 本地已有同名文件，或同名文件上传后已被删除但仍在队列中
+	writeSegment(t, r.outputDir, base+".mkv")
+	if got := r.mergedFileName(base); got != base+"_2.mkv" {
+		t.Errorf("mergedFileName() with local file = %s, want %s_2.mkv", got, base)
+	}
+	r.queue.Enqueue(UploadTask{Camera: "cam1", SrcPath: filepath.Join(r.outputDir, base+"_2.mkv"), DestPath: "cam1/" + base + "_2.mkv"})
+	if got := r.mergedFileName(base); got != base+"_3.mkv" {
+		t.Errorf("mergedFileName() with queued file = %s, want %s_3.mkv", got, base)
+	}
+}
+
+// fakeFFmpeg 在 PATH 中放入一个把 2KB 数据写入最后一个参数的 ffmpeg 脚本
+func fakeFFmpeg(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg script requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor last in \"$@\"; do :; done\nhead -c 2048 /dev/zero > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// drainQueue 依次处理队列中所有到期的任务
+func drainQueue(q *UploadQueue) {
+	for task := q.next(); task != nil; task = q.next() {
+		q.process(0, task)
+	}
+}
+
+func TestMergeKeepLocal(t *testing.T) {
+	fakeFFmpeg(t)
+	for _, mode := range []string{UploadModeMerged, UploadModeBoth} {
+		t.Run(mode, func(t *testing.T) {
+			r, backend := newTestRecorder(t, &UploadConfig{Mode: mode, KeepLocal: true, MaxFileAge: 30})
+			var segments []string
+			for _, span := range spansFrom(8, 0, 3) {
+				segments = append(segments, writeSegment(t, r.outputDir, span.path))
+			}
+			if mode == UploadModeBoth {
+				r.enqueueCompletedSegments(true)
+				drainQueue(r.queue)
+			}
+			merged := r.mergeCompletedGroups(true)
+			if len(merged) != 1 {
+				t.Fatalf("mergeCompletedGroups() = %v, want one merged file", merged)
+			}
+			drainQueue(r.queue)
+			uploads := len(backend.uploaded)
+
+			// 合并文件上传后片段仍保留在本地，但不会被再次合并
+			for _, path := range append(segments, merged...) {
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("kept file removed: %v", err)
+				}
+			}
+			if again := r.mergeCompletedGroups(true); len(again) != 0 {
+				t.Fatalf("second mergeCompletedGroups() = %v, want nothing", again)
+			}
+
+			// 超过已完成任务的保留时间并重新加载队列后仍然不会重新上传或合并
+			for _, path := range append(segments, merged...) {
+				old := time.Now().Add(-49 * time.Hour)
+				os.Chtimes(path, old, old)
+			}
+			r.queue.mu.Lock()
+			for _, task := range r.queue.tasks {
+				task.UpdatedAt = task.UpdatedAt.Add(-48 * time.Hour)
+			}
+			r.queue.save()
+			r.queue.mu.Unlock()
+			queue, err := NewUploadQueue(r.outputDir, r.uploader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.queue = queue
+			r.enqueueCompletedSegments(true)
+			if again := r.mergeCompletedGroups(true); len(again) != 0 {
+				t.Fatalf("mergeCompletedGroups() after reload = %v, want nothing", again)
+			}
+			drainQueue(r.queue)
+			if len(backend.uploaded) != uploads {
+				t.Errorf("uploaded %v, want no new uploads after reload", backend.uploaded[uploads:])
+			}
+
+			// 超过 max_file_age 后删除保留的文件和任务
+			r.queue.ExpireKeptFiles(time.Now().Add(31 * 24 * time.Hour))
+			for _, path := range append(segments, merged...) {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s not removed after max_file_age: %v", filepath.Base(path), err)
+				}
+			}
+			if tasks := r.queue.Tasks(); len(tasks) != 0 {
+				t.Errorf("%d tasks left after max_file_age", len(tasks))
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 计数器指标名称
+const (
+	metricFFmpegRestarts     = "autoupdatecam_ffmpeg_restarts_total"
+	metricSegmentsProduced   = "autoupdatecam_segments_produced_total"
+	metricInvalidSegments    = "autoupdatecam_invalid_segments_deleted_total"
+	metricUploadBytes        = "autoupdatecam_upload_bytes_total"
+	metricUploadAttempts     = "autoupdatecam_upload_attempts_total"
+	metricUploadFailures     = "autoupdatecam_upload_failures_total"
+	metricAlistLoginFailures = "autoupdatecam_alist_login_failures_total"
+)
+
+// counterHelp 计数器的说明，也决定了输出顺序
+var counterHelp = []struct {
+	name string
+	help string
+}{
+	{metricFFmpegRestarts, "Number of times ffmpeg failed to start or exited and was restarted."},
+	{metricSegmentsProduced, "Number of completed recording segments handed to the upload queue."},
+	{metricInvalidSegments, "Number of invalid (<1KB) segments deleted."},
+	{metricUploadBytes, "Number of bytes successfully uploaded."},
+	{metricUploadAttempts, "Number of upload attempts per worker."},
+	{metricUploadFailures, "Number of failed upload attempts per worker."},
+	{metricAlistLoginFailures, "Number of failed Alist logins."},
+}
+
+// Metrics 进程内的计数器集合，以 Prometheus 文本格式输出
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]map[string]float64 // 指标名 -> 标签 -> 值
+}
+
+// metrics 全局计数器
+var metrics = &Metrics{counters: make(map[string]map[string]float64)}
+
+// formatLabels 将 "key", "value" 形式的标签对格式化为 {key="value"}
+func formatLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Add 为计数器增加指定值
+func (m *Metrics) Add(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		m.counters[name] = series
+	}
+	series[formatLabels(labels...)] += value
+}
+
+// Inc 计数器加一
+func (m *Metrics) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+// writeCounters 以 Prometheus 文本格式输出所有计数器
+func (m *Metrics) writeCounters(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, counter := range counterHelp {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+		series := m.counters[counter.name]
+		keys := make([]string, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", counter.name, key, series[key])
+		}
+	}
+}
+
+// writeGauge 输出单个仪表盘指标的说明和取值
+func writeGauge(w io.Writer, name, help string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", name, key, values[key])
+	}
+}
+
+// handleMetrics 输出 Prometheus 指标，仪表盘指标在抓取时实时计算
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.writeCounters(w)
+
+	recording := make(map[string]float64)
+	retries := make(map[string]float64)
+	offline := make(map[string]float64)
+	diskFree := make(map[string]float64)
+	for _, recorder := range s.supervisor.Recorders() {
+		name := recorder.name
+		status := recorder.Status()
+		labels := formatLabels("camera", name)
+		if status.IsRecording {
+			recording[labels] = 1
+		} else {
+			recording[labels] = 0
+		}
+		retries[labels] = float64(status.RetryCount)
+		offline[labels] = 0
+		if status.Offline {
+			offline[labels] = 1
+		}
+		if free, err := diskFreeBytes(recorder.outputDir); err == nil {
+			diskFree[formatLabels("camera", name, "path", recorder.outputDir)] = float64(free)
+		}
+	}
+	writeGauge(w, "autoupdatecam_recording", "Whether the camera is currently recording.", recording)
+	writeGauge(w, "autoupdatecam_ffmpeg_retry_count", "Consecutive ffmpeg runs that produced no segment.", retries)
+	writeGauge(w, "autoupdatecam_camera_offline", "Whether the camera reached the offline threshold of consecutive failures.", offline)
+	writeGauge(w, "autoupdatecam_disk_free_bytes", "Free disk space available in the output directory.", diskFree)
+
+	queueTasks := make(map[string]float64)
+	for state, count := range s.queue.Stats() {
+		queueTasks[formatLabels("state", state)] = float64(count)
+	}
+	writeGauge(w, "autoupdatecam_upload_queue_tasks", "Number of tasks in the upload queue by state.", queueTasks)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 通知事件类型
+const (
+	EventCameraUnreachable = "camera_unreachable" // 连续失败达到离线阈值
+	EventCameraRecovered   = "camera_recovered"   // 离线后重新产生片段
+	EventRecordingStarted  = "recording_started"
+	EventRecordingStopped  = "recording_stopped"
+	EventUploadSummary     = "upload_summary" // 一次录制的上传结果
+	EventUploadFailed      = "upload_failed"  // 文件用完重试次数仍未上传成功
+)
+
+// notifyEvents 所有通知事件类型
+var notifyEvents = []string{
+	EventCameraUnreachable,
+	EventCameraRecovered,
+	EventRecordingStarted,
+	EventRecordingStopped,
+	EventUploadSummary,
+	EventUploadFailed,
+}
+
+const (
+	notifyQueueSize = 100
+	notifyTimeout   = 10 * time.Second
+)
+
+// NotifyConfig 通知配置，每种通知方式填写后即启用
+type NotifyConfig struct {
+	Events         string `json:"events"` // 需要通知的事件，逗号分隔，为空表示全部
+	WebhookURL     string `json:"webhook_url"`
+	SMTPHost       string `json:"smtp_host"`
+	SMTPPort       int    `json:"smtp_port"`
+	SMTPUser       string `json:"smtp_user"`
+	SMTPPass       string `json:"smtp_pass"`
+	SMTPFrom       string `json:"smtp_from"`
+	SMTPTo         string `json:"smtp_to"` // 收件人，逗号分隔
+	TelegramToken  string `json:"telegram_token"`
+	TelegramChatID string `json:"telegram_chat_id"`
+	ServerChanKey  string `json:"serverchan_key"`
+}
+
+// Event 通知事件
+type Event struct {
+	Type    string    `json:"type"`
+	Camera  string    `json:"camera,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Title 返回通知标题
+func (e Event) Title() string {
+	if e.Camera == "" {
+		return fmt.Sprintf("[autoUpdateCam] %s", e.Type)
+	}
+	return fmt.Sprintf("[autoUpdateCam] %s: %s", e.Camera, e.Type)
+}
+
+// NotifyTarget 通知发送方式
+type NotifyTarget interface {
+	Name() string
+	Send(event Event) error
+}
+
+// Notifier 异步发送通知，发送失败只记录日志，不影响录制和上传
+type Notifier struct {
+	targets []NotifyTarget
+	events  map[string]bool // 为空表示全部事件
+	mu      sync.Mutex
+	closed  bool
+	queue   chan Event
+	done    chan struct{}
+}
+
+// notifier 全局通知器，未配置通知方式时不发送任何通知
+var notifier = &Notifier{}
+
+// NewNotifier 根据配置创建通知器
+func NewNotifier(config *NotifyConfig) (*Notifier, error) {
+	n := &Notifier{
+		queue: make(chan Event, notifyQueueSize),
+		done:  make(chan struct{}),
+	}
+	events, err := parseNotifyEvents(config.Events)
+	if err != nil {
+		return nil, fmt.Errorf("notify.events: %v", err)
+	}
+	n.events = events
+
+	client := &http.Client{Timeout: notifyTimeout}
+	if config.WebhookURL != "" {
+		n.targets = append(n.targets, &WebhookTarget{url: config.WebhookURL, client: client})
+	}
+	if config.SMTPHost != "" {
+		if config.SMTPFrom == "" || config.SMTPTo == "" {
+			return nil, fmt.Errorf("notify.smtp_from and notify.smtp_to are required for email notifications")
+		}
+		port := config.SMTPPort
+		if port == 0 {
+			port = 587
+		}
+		n.targets = append(n.targets, &SMTPTarget{
+			addr:        net.JoinHostPort(config.SMTPHost, fmt.Sprintf("%d", port)),
+			host:        config.SMTPHost,
+			username:    config.SMTPUser,
+			password:    config.SMTPPass,
+			from:        config.SMTPFrom,
+			to:          splitList(config.SMTPTo),
+			implicitTLS: port == 465,
+		})
+	}
+	if config.TelegramToken != "" {
+		if config.TelegramChatID == "" {
+			return nil, fmt.Errorf("notify.telegram_chat_id is required for telegram notifications")
+		}
+		n.targets = append(n.targets, &TelegramTarget{token: config.TelegramToken, chatID: config.TelegramChatID, client: client})
+	}
+	if config.ServerChanKey != "" {
+		n.targets = append(n.targets, &ServerChanTarget{key: config.ServerChanKey, client: client})
+	}
+
+	if len(n.targets) > 0 {
+		names := make([]string, 0, len(n.targets))
+		for _, target := range n.targets {
+			names = append(names, target.Name())
+		}
+		slog.Info("Notifications enabled", "targets", strings.Join(names, ","))
+	}
+	go n.run()
+	return n, nil
+}
+
+// parseNotifyEvents 解析逗号分隔的事件列表，为空时返回 nil 表示全部事件
+// 事件名称拼写错误会导致通知被静默关闭，因此未知的事件返回错误
+func parseNotifyEvents(s string) (map[string]bool, error) {
+	names := splitList(s)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	events := make(map[string]bool)
+	for _, name := range names {
+		valid := false
+		for _, event := range notifyEvents {
+			valid = valid || name == event
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown event %q (expected %s)", name, strings.Join(notifyEvents, ", "))
+		}
+		events[name] = true
+	}
+	return events, nil
+}
+
+// splitList 解析逗号分隔的列表
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Notify 将事件加入发送队列，队列已满时丢弃，不会阻塞调用方
+func (n *Notifier) Notify(eventType, camera, format string, args ...interface{}) {
+	if len(n.targets) == 0 || (n.events != nil && !n.events[eventType]) {
+		return
+	}
+	event := Event{
+		Type:    eventType,
+		Camera:  camera,
+		Message: fmt.Sprintf(format, args...),
+		Time:    time.Now(),
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- event:
+	default:
+		slog.Warn("Notification queue full, dropping event", "event", eventType, "camera", camera)
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for event := range n.queue {
+		for _, target := range n.targets {
+			if err := target.Send(event); err != nil {
+				slog.Warn("Failed to send notification", "event", event.Type, "target", target.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// Close 停止接收新的事件，并在 timeout 内发送完队列中的通知
+func (n *Notifier) Close(timeout time.Duration) {
+	n.mu.Lock()
+	if n.queue == nil || n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+
+	select {
+	case <-n.done:
+	case <-time.After(timeout):
+		slog.Warn("Timed out sending pending notifications")
+	}
+}
+
+// WebhookTarget 以 JSON POST 事件到指定地址
+type WebhookTarget struct {
+	url    string
+	client *http.Client
+}
+
+func (t *WebhookTarget) Name() string { return "webhook" }
+
+func (t *WebhookTarget) Send(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return checkNotifyResponse(resp)
+}
+
+// SMTPTarget 通过 SMTP 发送邮件，465 端口使用 TLS 直接连接，其他端口在服务器支持时使用 STARTTLS
+type SMTPTarget struct {
+	addr        string
+	host        string
+	username    string
+	password    string
+	from        string
+	to          []string
+	implicitTLS bool
+}
+
+func (t *SMTPTarget) Name() string { return "smtp" }
+
+func (t *SMTPTarget) Send(event Event) error {
+	var auth smtp.Auth
+	if t.username != "" {
+		auth = smtp.PlainAuth("", t.username, t.password, t.host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n\r\n%s\r\n",
+		t.from, strings.Join(t.to, ", "), event.Title(), event.Message, event.Time.Format(time.RFC3339))
+	if !t.implicitTLS {
+		return smtp.SendMail(t.addr, auth, t.from, t.to, []byte(msg))
+	}
+
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", t.addr, &tls.Config{ServerName: t.host})
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	client, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(t.from); err != nil {
+		return err
+	}
+	for _, to := range t.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// TelegramTarget 通过 Telegram Bot 发送消息
+type TelegramTarget struct {
+	token  string
+	chatID string
+	client *http.Client
+}
+
+func (t *TelegramTarget) Name() string { return "telegram" }
+
+func (t *TelegramTarget) Send(event Event) error {
+	resp, err := t.client.PostForm("https://api.telegram.org/bot"+t.token+"/sendMessage", url.Values{
+		"chat_id": {t.chatID},
+		"text":    {event.Title() + "\n" + event.Message},
+	})
+	if err != nil {
+		// 错误信息中的 URL 包含 token，不直接输出
+		return fmt.Errorf("failed to send request")
+	}
+	return checkNotifyResponse(resp)
+}
+
+// ServerChanTarget 通过 Server 酱推送到微信
+type ServerChanTarget struct {
+	key    string
+	client *http.Client
+}
+
+func (t *ServerChanTarget) Name() string { return "serverchan" }
+
+func (t *ServerChanTarget) Send(event Event) error {
+	resp, err := t.client.PostForm("https://sctapi.ftqq.com/"+t.key+".send", url.Values{
+		"title": {event.Title()},
+		"desp":  {event.Message + "\n\n" + event.Time.Format(time.RFC3339)},
+	})
+	if err != nil {
+		// 错误信息中的 URL 包含 SendKey，不直接输出
+		return fmt.Errorf("failed to send request")
+	}
+	return checkNotifyResponse(resp)
+}
+
+func checkNotifyResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
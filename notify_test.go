@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNotifyEvents(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{spec: "", want: nil},
+		{spec: " , ", want: nil},
+		{spec: "upload_failed", want: map[string]bool{EventUploadFailed: true}},
+		{
+			spec: "camera_unreachable, camera_recovered,upload_failed",
+			want: map[string]bool{EventCameraUnreachable: true, EventCameraRecovered: true, EventUploadFailed: true},
+		},
+		{spec: "upload_faild", wantErr: true},
+		{spec: "camera_unreachable,Upload_Failed", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseNotifyEvents(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNotifyEvents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNotifyEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 上传任务状态
+const (
+	TaskPending   = "pending"
+	TaskUploading = "uploading"
+	TaskDone      = "done"
+	TaskFailed    = "failed"
+)
+
+const (
+	queueFileName     = ".upload_queue.json" // 队列日志文件名，保存在录制根目录下
+	maxRetryBackoff   = time.Hour            // 重试间隔上限
+	doneTaskRetained  = 24 * time.Hour       // 已完成任务在日志中的保留时间，保留了本地文件的任务除外
+	keptFilesInterval = time.Hour            // 检查保留的本地文件是否超过 upload.max_file_age 的间隔
+)
+
+// UploadTask 上传队列中的单个任务
+type UploadTask struct {
+	Camera    string    `json:"camera"`
+	SrcPath   string    `json:"src_path"`
+	DestPath  string    `json:"dest_path"`
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRetry time.Time `json:"next_retry"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Keep      bool      `json:"keep,omitempty"`    // 上传成功后保留本地文件，等待合并文件上传后再删除，或配置了 upload.keep_local
+	Cleanup   []string  `json:"cleanup,omitempty"` // 合并文件的原始片段，上传成功后删除，配置了 upload.keep_local 时保留
+}
+
+// keepsLocalFiles 任务上传成功后是否仍有保留在本地的文件
+// 这类任务在日志中保留到文件被删除，避免保留的片段被重新上传或重新合并
+func (t *UploadTask) keepsLocalFiles() bool {
+	return t.Keep || len(t.Cleanup) > 0
+}
+
+// UploadQueue 持久化的上传队列，任务状态写入磁盘，重启后继续重试直到上传成功
+type UploadQueue struct {
+	path     string
+	uploader *FileUploader
+	mu       sync.Mutex
+	tasks    map[string]*UploadTask // 以本地文件路径为键
+	changed  chan struct{}          // 任务状态变化时通知等待者
+	wake     chan struct{}
+	closed   bool // 关闭后不再开始新的上传
+}
+
+// NewUploadQueue 创建上传队列并回放磁盘上的队列日志
+func NewUploadQueue(outputDir string, uploader *FileUploader) (*UploadQueue, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %v", err)
+	}
+
+	q := &UploadQueue{
+		path:     filepath.Join(absOutputDir, queueFileName),
+		uploader: uploader,
+		tasks:    make(map[string]*UploadTask),
+		changed:  make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// load 读取队列日志，将中断的上传重置为待上传
+func (q *UploadQueue) load() error {
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload queue: %v", err)
+	}
+
+	var tasks []*UploadTask
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return fmt.Errorf("failed to parse upload queue %s: %v", q.path, err)
+	}
+
+	pending := 0
+	for _, task := range tasks {
+		if task.State == TaskUploading {
+			task.State = TaskPending
+		}
+		if task.State != TaskDone {
+			pending++
+		}
+		q.tasks[task.SrcPath] = task
+	}
+	slog.Info("Loaded upload queue", "path", q.path, "unfinished", pending)
+	return q.save()
+}
+
+// save 将队列写入磁盘（先写临时文件再重命名），调用方需持有锁
+func (q *UploadQueue) save() error {
+	now := time.Now()
+	tasks := make([]*UploadTask, 0, len(q.tasks))
+	for key, task := range q.tasks {
+		if task.State == TaskDone && !task.keepsLocalFiles() && now.Sub(task.UpdatedAt) > doneTaskRetained {
+			delete(q.tasks, key)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload queue: %v", err)
+	}
+	tmpPath := q.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload queue: %v", err)
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return fmt.Errorf("failed to replace upload queue: %v", err)
+	}
+	return nil
+}
+
+// notify 唤醒空闲的上传协程和等待者，调用方需持有锁
+func (q *UploadQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue 添加上传任务，已在队列中且未完成的文件会被忽略，返回是否新加入了任务
+// 只需填写 Camera、SrcPath、DestPath 以及可选的 Keep、Cleanup
+func (q *UploadQueue) Enqueue(task UploadTask) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if existing, ok := q.tasks[task.SrcPath]; ok {
+		if existing.State != TaskDone {
+			return false
+		}
+		// 已上传的文件被删除或保留的文件未被重新写入时不再重复加入
+		info, err := os.Stat(task.SrcPath)
+		if err != nil || !info.ModTime().After(existing.UpdatedAt) {
+			return false
+		}
+	}
+
+	now := time.Now()
+	task.State = TaskPending
+	task.Attempts = 0
+	task.LastError = ""
+	task.NextRetry = time.Time{}
+	task.CreatedAt = now
+	task.UpdatedAt = now
+	q.tasks[task.SrcPath] = &task
+	if err := q.save(); err != nil {
+		slog.Warn("Failed to persist upload queue", "error", err)
+	}
+	q.notify()
+	return true
+}
+
+// Start 启动指定数量的上传协程
+func (q *UploadQueue) Start(workers int) {
+	if workers <= 0 {
+		workers = 3 // 默认值
+	}
+	slog.Info("Starting upload workers", "workers", workers)
+	for i := 0; i < workers; i++ {
+		go q.worker(i)
+	}
+	go func() {
+		for {
+			q.ExpireKeptFiles(time.Now())
+			time.Sleep(keptFilesInterval)
+		}
+	}()
+}
+
+func (q *UploadQueue) worker(workerID int) {
+	for {
+		task := q.next()
+		if task == nil {
+			select {
+			case <-q.wake:
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		q.process(workerID, task)
+	}
+}
+
+// next 取出一个到期的任务并标记为上传中
+func (q *UploadQueue) next() *UploadTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	now := time.Now()
+	var selected *UploadTask
+	for _, task := range q.tasks {
+		if task.State != TaskPending && task.State != TaskFailed {
+			continue
+		}
+		if task.NextRetry.After(now) {
+			continue
+		}
+		if selected == nil || task.CreatedAt.Before(selected.CreatedAt) {
+			selected = task
+		}
+	}
+	if selected == nil {
+		return nil
+	}
+
+	selected.State = TaskUploading
+	selected.UpdatedAt = now
+	if err := q.save(); err != nil {
+		slog.Warn("Failed to persist upload queue", "error", err)
+	}
+	copied := *selected
+	return &copied
+}
+
+// process 执行一次上传尝试并记录结果
+func (q *UploadQueue) process(workerID int, task *UploadTask) {
+	config := q.uploader.Config()
+	attempt := task.Attempts + 1
+	logger := slog.With("camera", task.Camera, "worker_id", workerID, "segment", filepath.Base(task.SrcPath), "attempt", attempt)
+	logger.Info("Uploading file", "dest", task.DestPath)
+
+	srcInfo, err := os.Stat(task.SrcPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// 源文件已不存在，无法继续重试
+		logger.Warn("Source file no longer exists, dropping task", "file", task.SrcPath)
+		q.mu.Lock()
+		delete(q.tasks, task.SrcPath)
+		if err := q.save(); err != nil {
+			slog.Warn("Failed to persist upload queue", "error", err)
+		}
+		q.notify()
+		q.mu.Unlock()
+		return
+	}
+
+	worker := fmt.Sprintf("%d", workerID)
+	metrics.Inc(metricUploadAttempts, "worker", worker)
+	uploadErr := q.uploader.UploadFile(task.SrcPath, task.DestPath, task.Keep)
+	if uploadErr != nil {
+		metrics.Inc(metricUploadFailures, "worker", worker)
+	} else if srcInfo != nil {
+		metrics.Add(metricUploadBytes, float64(srcInfo.Size()), "camera", task.Camera)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	current, ok := q.tasks[task.SrcPath]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	current.Attempts = attempt
+	current.UpdatedAt = now
+	if uploadErr != nil {
+		current.State = TaskFailed
+		current.LastError = uploadErr.Error()
+		current.NextRetry = now.Add(retryBackoff(config, attempt))
+		logger.Warn("Upload attempt failed", "error", uploadErr, "next_retry", current.NextRetry.Format("15:04:05"))
+		// 用完 RetryCount 次尝试时通知一次，之后任务仍会按退避间隔继续重试
+		if attempt == max(config.RetryCount, 1) {
+			notifier.Notify(EventUploadFailed, task.Camera, "Upload of %s failed after %d attempts: %v",
+				filepath.Base(task.SrcPath), attempt, uploadErr)
+		}
+	} else {
+		current.State = TaskDone
+		current.LastError = ""
+		logger.Info("Uploaded file")
+		q.release(current.Camera, current.Cleanup)
+		if !config.KeepLocal {
+			current.Cleanup = nil
+		}
+	}
+	if err := q.save(); err != nil {
+		slog.Warn("Failed to persist upload queue", "error", err)
+	}
+	q.notify()
+}
+
+// Release 不再需要保留的本地片段：已上传的立即删除，仍在等待上传的改为上传成功后删除
+func (q *UploadQueue) Release(camera string, srcPaths []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.release(camera, srcPaths)
+	if err := q.save(); err != nil {
+		slog.Warn("Failed to persist upload queue", "error", err)
+	}
+}
+
+// release 删除合并文件的原始片段，配置了 upload.keep_local 时保留，调用方需持有锁
+func (q *UploadQueue) release(camera string, srcPaths []string) {
+	if q.uploader.Config().KeepLocal {
+		return
+	}
+	removed := 0
+	for _, srcPath := range srcPaths {
+		if task, ok := q.tasks[srcPath]; ok {
+			task.Keep = false
+			if task.State != TaskDone {
+				continue
+			}
+		}
+		if err := os.Remove(srcPath); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to remove segment", "camera", camera, "segment", filepath.Base(srcPath), "error", err)
+			}
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		slog.Info("Removed local segments", "camera", camera, "count", removed)
+	}
+}
+
+// ExpireKeptFiles 删除上传成功后保留超过 upload.max_file_age 天的本地文件（包括合并文件的原始片段）并移除对应的任务
+func (q *UploadQueue) ExpireKeptFiles(now time.Time) {
+	maxAge := q.uploader.Config().MaxFileAge
+	if maxAge <= 0 {
+		return
+	}
+	cutoff := now.Add(-time.Duration(maxAge) * 24 * time.Hour)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	expired, removed := 0, 0
+	for key, task := range q.tasks {
+		if task.State != TaskDone || !task.keepsLocalFiles() || task.UpdatedAt.After(cutoff) {
+			continue
+		}
+		for _, srcPath := range append([]string{task.SrcPath}, task.Cleanup...) {
+			if err := os.Remove(srcPath); err == nil {
+				removed++
+			} else if !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to remove expired file", "camera", task.Camera, "file", filepath.Base(srcPath), "error", err)
+			}
+		}
+		delete(q.tasks, key)
+		expired++
+	}
+	if expired == 0 {
+		return
+	}
+	slog.Info("Removed expired local files", "files", removed, "max_file_age", maxAge)
+	if err := q.save(); err != nil {
+		slog.Warn("Failed to persist upload queue", "error", err)
+	}
+}
+
+// Claimed 返回已合并的片段，包括等待合并文件上传成功后删除的和配置了 upload.keep_local 时保留的
+func (q *UploadQueue) Claimed() map[string]bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	claimed := make(map[string]bool)
+	for _, task := range q.tasks {
+		for _, srcPath := range task.Cleanup {
+			claimed[srcPath] = true
+		}
+	}
+	return claimed
+}
+
+// Files 返回队列中位于 dir 目录下的文件路径（包括最近上传完成的任务）
+func (q *UploadQueue) Files(dir string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var files []string
+	for srcPath := range q.tasks {
+		if filepath.Dir(srcPath) == dir {
+			files = append(files, srcPath)
+		}
+	}
+	return files
+}
+
+// Has 返回文件是否在队列中（包括最近上传完成的任务）
+func (q *UploadQueue) Has(srcPath string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.tasks[srcPath]
+	return ok
+}
+
+// retryBackoff 计算下一次重试的等待时间：前 RetryCount 次使用固定间隔，之后按指数退避
+func retryBackoff(config *UploadConfig, attempt int) time.Duration {
+	delay := time.Duration(config.RetryDelay) * time.Second
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for i := config.RetryCount; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay
+}
+
+// WaitFor 等待指定文件上传成功或用完 RetryCount 次尝试，返回上传成功的数量
+func (q *UploadQueue) WaitFor(srcPaths []string) int {
+	for {
+		q.mu.Lock()
+		finished, succeeded := 0, 0
+		for _, srcPath := range srcPaths {
+			task, ok := q.tasks[srcPath]
+			switch {
+			case !ok:
+				finished++
+			case task.State == TaskDone:
+				finished++
+				succeeded++
+			case task.State == TaskFailed && task.Attempts >= q.uploader.Config().RetryCount:
+				finished++
+			}
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		if finished == len(srcPaths) {
+			return succeeded
+		}
+		<-changed
+	}
+}
+
+// Shutdown 在 timeout 内继续上传已到期的任务并等待正在进行的上传完成，
+// 然后停止开始新的上传并保存队列，未完成的任务在下次启动时继续上传
+func (q *UploadQueue) Shutdown(timeout time.Duration) {
+	deadline := time.After(timeout)
+	timedOut := false
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		busy := 0
+		for _, task := range q.tasks {
+			switch {
+			case task.State == TaskPending, task.State == TaskUploading:
+				busy++
+			case task.State == TaskFailed && !task.NextRetry.After(now):
+				busy++
+			}
+		}
+		if busy == 0 || timedOut {
+			q.closed = true
+			for _, task := range q.tasks {
+				if task.State == TaskUploading {
+					task.State = TaskPending
+				}
+			}
+			if err := q.save(); err != nil {
+				slog.Warn("Failed to persist upload queue", "error", err)
+			}
+			q.mu.Unlock()
+			slog.Info("Upload queue saved", "path", q.path, "unfinished", busy)
+			return
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			timedOut = true
+		}
+	}
+}
+
+// Stats 返回各状态的任务数量
+func (q *UploadQueue) Stats() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := map[string]int{
+		TaskPending:   0,
+		TaskUploading: 0,
+		TaskDone:      0,
+		TaskFailed:    0,
+	}
+	for _, task := range q.tasks {
+		stats[task.State]++
+	}
+	return stats
+}
+
+// Tasks 返回队列中所有任务的副本，按加入时间排序
+func (q *UploadQueue) Tasks() []UploadTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tasks := make([]UploadTask, 0, len(q.tasks))
+	for _, task := range q.tasks {
+		tasks = append(tasks, *task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+	return tasks
+}
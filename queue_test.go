@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUploader 记录上传的文件，failing 中的文件上传失败
+type fakeUploader struct {
+	mu       sync.Mutex
+	failing  map[string]bool
+	uploaded []string
+}
+
+func (f *fakeUploader) Upload(srcPath, destPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing[filepath.Base(srcPath)] {
+		return errors.New("upload failed")
+	}
+	f.uploaded = append(f.uploaded, destPath)
+	return nil
+}
+
+func (f *fakeUploader) Exists(destPath string) (bool, error) { return false, nil }
+func (f *fakeUploader) Delete(destPath string) error         { return nil }
+func (f *fakeUploader) List(dir string) ([]string, error)    { return nil, nil }
+
+// newTestUploader 使用 fakeUploader 作为存储后端创建上传器
+func newTestUploader(config *UploadConfig, backend *fakeUploader) *FileUploader {
+	u := &FileUploader{}
+	u.state.Store(&uploaderState{config: config, backend: backend})
+	return u
+}
+
+// writeSegment 在 dir 下创建一个 2KB 的片段文件并返回其路径
+func writeSegment(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUploadQueueReplay(t *testing.T) {
+	dir := t.TempDir()
+	config := &UploadConfig{Mode: UploadModeSegments, RetryCount: 3, RetryDelay: 60}
+	backend := &fakeUploader{failing: map[string]bool{"b.mkv": true}}
+	q, err := NewUploadQueue(dir, newTestUploader(config, backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv"} {
+		if !q.Enqueue(UploadTask{Camera: "cam1", SrcPath: writeSegment(t, dir, name), DestPath: "cam1/" + name}) {
+			t.Fatalf("Enqueue(%s) = false", name)
+		}
+		time.Sleep(time.Millisecond) // 保证加入顺序
+	}
+	// a 上传成功，b 上传失败，c 在上传过程中程序退出
+	q.process(0, q.next())
+	q.process(0, q.next())
+	if task := q.next(); task == nil || filepath.Base(task.SrcPath) != "c.mkv" {
+		t.Fatalf("next() = %+v, want c.mkv", task)
+	}
+
+	// 丢弃原队列，从队列日志重新加载
+	backend = &fakeUploader{}
+	q, err = NewUploadQueue(dir, newTestUploader(config, backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		state    string
+		attempts int
+	}{
+		"a.mkv": {TaskDone, 1},
+		"b.mkv": {TaskFailed, 1},
+		"c.mkv": {TaskPending, 0},
+	}
+	tasks := q.Tasks()
+	if len(tasks) != len(want) {
+		t.Fatalf("reloaded %d tasks, want %d", len(tasks), len(want))
+	}
+	for _, task := range tasks {
+		w := want[filepath.Base(task.SrcPath)]
+		if task.State != w.state || task.Attempts != w.attempts {
+			t.Errorf("%s: state %s attempts %d, want %s attempts %d",
+				filepath.Base(task.SrcPath), task.State, task.Attempts, w.state, w.attempts)
+		}
+		if task.State == TaskFailed && (task.LastError == "" || task.NextRetry.IsZero()) {
+			t.Errorf("%s: failed task lost its error or retry time: %+v", filepath.Base(task.SrcPath), task)
+		}
+	}
+
+	// 中断的 c 立即继续上传，b 等到重试时间后继续并保留尝试次数
+	q.process(0, q.next())
+	if task := q.next(); task != nil {
+		t.Fatalf("next() = %s before retry time", task.SrcPath)
+	}
+	q.mu.Lock()
+	q.tasks[filepath.Join(dir, "b.mkv")].NextRetry = time.Now()
+	q.mu.Unlock()
+	q.process(0, q.next())
+	for _, task := range q.Tasks() {
+		if task.State != TaskDone {
+			t.Errorf("%s: state %s, want done", filepath.Base(task.SrcPath), task.State)
+		}
+		if filepath.Base(task.SrcPath) == "b.mkv" && task.Attempts != 2 {
+			t.Errorf("b.mkv: attempts %d, want 2", task.Attempts)
+		}
+	}
+	if len(backend.uploaded) != 2 {
+		t.Errorf("uploaded %v after reload, want c and b", backend.uploaded)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	config := &UploadConfig{RetryCount: 3, RetryDelay: 5}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 5 * time.Second},
+		{3, 5 * time.Second},
+		{4, 10 * time.Second},
+		{6, 40 * time.Second},
+		{100, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(config, tt.attempt); got != tt.want {
+			t.Errorf("retryBackoff(attempt %d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+	if got := retryBackoff(&UploadConfig{RetryCount: 3}, 1); got != time.Second {
+		t.Errorf("retryBackoff without delay = %v, want 1s", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+// reconnectDelay 计算连续失败 failures 次后的重连等待时间
+// 按指数退避增长到 reconnectMax，并在 [delay/2, delay] 之间随机抖动，避免多个摄像头同时重连
+func (r *Recorder) reconnectDelay(failures int) time.Duration {
+	delay := r.reconnectBase
+	if delay <= 0 {
+		delay = 5 * time.Second
+	}
+	for i := 1; i < failures && (r.reconnectMax <= 0 || delay < r.reconnectMax); i++ {
+		delay *= 2
+	}
+	if r.reconnectMax > 0 && delay > r.reconnectMax {
+		delay = r.reconnectMax
+	}
+	if failures == 0 {
+		return delay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// segmentProducedSince 检查 ffmpeg 在 since 之后是否写出了有效的片段数据
+func (r *Recorder) segmentProducedSince(since time.Time) bool {
+	entries, err := os.ReadDir(r.outputDir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !r.isSegmentFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.Size() >= 1024 && info.ModTime().After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordFailure 记录一次连续失败，达到 offlineThreshold 时将摄像头标记为离线，调用方需持有锁
+func (r *Recorder) recordFailure() {
+	r.retryCount++
+	if r.offline || r.offlineThreshold <= 0 || r.retryCount < r.offlineThreshold {
+		return
+	}
+	r.offline = true
+	r.offlineSince = time.Now()
+	r.logger.Error("ALERT: camera offline", "consecutive_failures", r.retryCount)
+	if r.probeErr != nil {
+		notifier.Notify(EventCameraUnreachable, r.name, "Camera offline after %d consecutive failures: %v", r.retryCount, r.probeErr)
+	} else {
+		notifier.Notify(EventCameraUnreachable, r.name, "Camera offline after %d consecutive failures", r.retryCount)
+	}
+}
+
+// markOnline 摄像头产生了新的片段，重置连续失败次数和离线状态
+func (r *Recorder) markOnline() {
+	r.mu.Lock()
+	wasOffline, offlineSince := r.offline, r.offlineSince
+	r.retryCount = 0
+	r.offline = false
+	r.mu.Unlock()
+
+	if wasOffline {
+		r.logger.Info("Camera back online", "offline_for", time.Since(offlineSince).Round(time.Second).String())
+		notifier.Notify(EventCameraRecovered, r.name, "Camera back online after %v", time.Since(offlineSince).Round(time.Second))
+	}
+}
+
+// checkOnline 录制期间检查 ffmpeg 是否已经写出片段，是则重置连续失败次数
+func (r *Recorder) checkOnline() {
+	r.mu.Lock()
+	failing, started := r.retryCount > 0 || r.offline, r.cmdStarted
+	r.mu.Unlock()
+	if failing && !started.IsZero() && r.segmentProducedSince(started) {
+		r.markOnline()
+	}
+}
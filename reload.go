@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configWatchInterval 检查配置文件是否修改的间隔
+const configWatchInterval = 5 * time.Second
+
+// restartRequiredKeys 修改后需要重启程序才能生效的配置项，以 "." 结尾的表示该前缀下的所有配置项
+var restartRequiredKeys = []string{
+	"recording.output_dir", // 上传队列文件所在目录
+	"upload.max_concurrent",
+	"http.",
+	"notify.",
+	"log.format",
+}
+
+// managedRecorder 由 Supervisor 启动的录制器
+type managedRecorder struct {
+	recorder *Recorder
+	camera   CameraConfig
+	cancel   context.CancelFunc
+	done     chan struct{} // Run 返回后关闭
+}
+
+// Supervisor 管理所有摄像头的录制器，重新加载配置时只重启受影响的录制器
+type Supervisor struct {
+	ctx       context.Context
+	queue     *UploadQueue
+	mu        sync.Mutex
+	config    *Config
+	recorders map[string]*managedRecorder
+	names     []string // 按配置顺序排列的摄像头名称
+	wg        sync.WaitGroup
+}
+
+// NewSupervisor 创建录制器管理，ctx 取消时所有录制器停止录制并退出
+func NewSupervisor(ctx context.Context, queue *UploadQueue) *Supervisor {
+	return &Supervisor{
+		ctx:       ctx,
+		queue:     queue,
+		recorders: make(map[string]*managedRecorder),
+	}
+}
+
+// Recorders 按配置顺序返回当前的录制器
+func (s *Supervisor) Recorders() []*Recorder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recorders := make([]*Recorder, 0, len(s.names))
+	for _, name := range s.names {
+		recorders = append(recorders, s.recorders[name].recorder)
+	}
+	return recorders
+}
+
+// Recorder 返回指定名称的录制器
+func (s *Supervisor) Recorder(name string) (*Recorder, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.recorders[name]
+	if !ok {
+		return nil, false
+	}
+	return m.recorder, true
+}
+
+// Apply 按新的配置启动、重启或停止录制器，只能在一个协程中调用
+// 只有录制计划变化的摄像头不会重启，新的录制计划在当前录制结束后生效
+func (s *Supervisor) Apply(config *Config, cameras []CameraConfig) error {
+	schedules := make(map[string]*Schedule, len(cameras))
+	for _, camera := range cameras {
+		schedule, err := NewSchedule(camera.Schedule)
+		if err != nil {
+			return err
+		}
+		schedules[camera.Name] = schedule
+	}
+
+	s.mu.Lock()
+	old := s.config
+	var stale []*managedRecorder
+	var added []CameraConfig
+	wanted := make(map[string]bool, len(cameras))
+	for _, camera := range cameras {
+		wanted[camera.Name] = true
+		m, ok := s.recorders[camera.Name]
+		switch {
+		case !ok:
+			added = append(added, camera)
+		case recorderChanged(old, config, m.camera, camera):
+			m.recorder.logger.Info("Camera config changed, restarting recorder")
+			stale = append(stale, m)
+			added = append(added, camera)
+		case !reflect.DeepEqual(m.camera.Schedule, camera.Schedule):
+			m.recorder.logger.Info("Schedule changed, applying after the current recording", "schedule", schedules[camera.Name].String())
+			m.recorder.SetSchedule(schedules[camera.Name])
+			m.camera = camera
+		}
+	}
+	for name, m := range s.recorders {
+		if !wanted[name] {
+			m.recorder.logger.Info("Camera removed from config, stopping recorder")
+			stale = append(stale, m)
+		}
+	}
+	s.mu.Unlock()
+
+	// 先停止旧的录制器，避免新旧录制器同时写入同一个目录
+	for _, m := range stale {
+		m.cancel()
+		<-m.done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range stale {
+		delete(s.recorders, m.camera.Name)
+	}
+	for _, camera := range added {
+		s.start(config, camera, schedules[camera.Name])
+	}
+	s.names = s.names[:0]
+	for _, camera := range cameras {
+		s.names = append(s.names, camera.Name)
+	}
+	s.config = config
+	return nil
+}
+
+// start 创建录制器并按录制计划运行，调用方需持有锁
+func (s *Supervisor) start(config *Config, camera CameraConfig, schedule *Schedule) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	m := &managedRecorder{
+		recorder: NewRecorder(config, camera, schedule, s.queue),
+		camera:   camera,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	s.recorders[camera.Name] = m
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(m.done)
+		m.recorder.Run(ctx)
+	}()
+}
+
+// Wait 等待所有录制器退出
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// reloadConfig 将重新加载的配置应用到上传、日志级别和录制器，需要重启才能生效的配置项只输出警告
+func reloadConfig(oldConfig, newConfig *Config, uploader *FileUploader, supervisor *Supervisor) error {
+	cameras, err := newConfig.CameraList()
+	if err != nil {
+		return err
+	}
+	level, err := parseLogLevel(newConfig.Log.Level)
+	if err != nil {
+		return err
+	}
+	// 正在重启的录制器仍可能输出旧的密码
+	setLogSecrets(append(oldConfig.secrets(), newConfig.secrets()...))
+	if !reflect.DeepEqual(oldConfig.Upload, newConfig.Upload) {
+		if err := uploader.Update(&newConfig.Upload); err != nil {
+			return err
+		}
+		slog.Info("Upload config updated")
+	}
+	logLevel.Set(level)
+	if keys := restartRequired(oldConfig, newConfig); len(keys) > 0 {
+		slog.Warn("Some config changes require a restart to take effect", "keys", strings.Join(keys, ", "))
+	}
+	return supervisor.Apply(newConfig, cameras)
+}
+
+// recorderChanged 判断摄像头的连接信息或录制参数是否变化，变化时需要重启录制器
+func recorderChanged(oldConfig, newConfig *Config, oldCamera, newCamera CameraConfig) bool {
+	oldCamera.Schedule, newCamera.Schedule = nil, nil
+	if !reflect.DeepEqual(oldCamera, newCamera) {
+		return true
+	}
+	oldRecording, newRecording := oldConfig.Recording, newConfig.Recording
+	oldRecording.ScheduleConfig, newRecording.ScheduleConfig = ScheduleConfig{}, ScheduleConfig{}
+	return !reflect.DeepEqual(oldRecording, newRecording)
+}
+
+// restartRequired 返回修改后需要重启程序才能生效的配置项
+func restartRequired(oldConfig, newConfig *Config) []string {
+	oldValues := make(map[string]interface{})
+	for _, field := range configFields(oldConfig) {
+		oldValues[field.key] = field.value.Interface()
+	}
+	var keys []string
+	for _, field := range configFields(newConfig) {
+		for _, key := range restartRequiredKeys {
+			if (field.key == key || strings.HasSuffix(key, ".") && strings.HasPrefix(field.key, key)) &&
+				!reflect.DeepEqual(oldValues[field.key], field.value.Interface()) {
+				keys = append(keys, field.key)
+			}
+		}
+	}
+	return keys
+}
+
+// watchConfig 配置文件修改或收到 SIGHUP 时调用 reload，直到 ctx 被取消
+// 使用轮询检查文件的修改时间和大小，兼容 Docker 挂载的配置文件
+func watchConfig(ctx context.Context, path string, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	modTime, size := stat()
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading config")
+			modTime, size = stat()
+			reload()
+		case <-ticker.C:
+			newModTime, newSize := stat()
+			if newModTime.Equal(modTime) && newSize == size {
+				continue
+			}
+			modTime, size = newModTime, newSize
+			slog.Info("Config file changed, reloading config", "path", path)
+			reload()
+		}
+	}
+}
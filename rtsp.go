@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rtspProbeTimeout 探测摄像头时的连接和读写超时
+const rtspProbeTimeout = 5 * time.Second
+
+// RTSP 探测错误类型，使用 errors.Is 判断
+var (
+	ErrCameraUnreachable = errors.New("camera unreachable")
+	ErrAuthFailed        = errors.New("authentication failed")
+	ErrStreamNotFound    = errors.New("stream not found")
+)
+
+// probeErrorType 返回探测错误的类型名称
+func probeErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrCameraUnreachable):
+		return "unreachable"
+	case errors.Is(err, ErrAuthFailed):
+		return "auth_failed"
+	case errors.Is(err, ErrStreamNotFound):
+		return "stream_not_found"
+	default:
+		return "other"
+	}
+}
+
+// RTSPProbe 在启动 ffmpeg 之前检查摄像头是否可以连接、认证并找到码流
+type RTSPProbe struct {
+	Address  string // host:port
+	Path     string // 码流路径，如 /cam/realmonitor?channel=1&subtype=0
+	Username string
+	Password string
+	Timeout  time.Duration
+
+	mu    sync.Mutex // 保护 nonce 和 nc，认证代理的多个连接共用同一个探测器
+	nonce string     // 最近一次 Digest 认证使用的 nonce
+	nc    uint32     // 该 nonce 已经使用的次数
+}
+
+// rtspResponse RTSP 响应
+type rtspResponse struct {
+	StatusCode int
+	Status     string
+	Header     textproto.MIMEHeader
+}
+
+// NewRTSPProbe 根据摄像头配置创建探测器
+func NewRTSPProbe(camera CameraConfig) *RTSPProbe {
+	return &RTSPProbe{
+		Address:  net.JoinHostPort(camera.IP, camera.Port),
+		Path:     "/" + strings.TrimPrefix(camera.Stream, "/"),
+		Username: camera.Username,
+		Password: camera.Password,
+		Timeout:  rtspProbeTimeout,
+	}
+}
+
+// Probe 依次发送 OPTIONS 和 DESCRIBE 请求，需要认证时使用 Digest 或 Basic 认证重试
+func (p *RTSPProbe) Probe() error {
+	conn, err := net.DialTimeout("tcp", p.Address, p.Timeout)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCameraUnreachable, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(p.Timeout)); err != nil {
+		return fmt.Errorf("%w: %v", ErrCameraUnreachable, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	uri := "rtsp://" + p.Address + p.Path
+	cseq := 0
+	send := func(method, authorization string) (*rtspResponse, error) {
+		cseq++
+		request := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: autoUpdateCam\r\n", method, uri, cseq)
+		if method == "DESCRIBE" {
+			request += "Accept: application/sdp\r\n"
+		}
+		if authorization != "" {
+			request += "Authorization: " + authorization + "\r\n"
+		}
+		if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+			return nil, fmt.Errorf("%w: failed to send %s: %v", ErrCameraUnreachable, method, err)
+		}
+		resp, err := readRTSPResponse(reader)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read %s response: %v", ErrCameraUnreachable, method, err)
+		}
+		return resp, nil
+	}
+
+	// OPTIONS 通常不需要认证，只用于确认对端是 RTSP 服务
+	if _, err := send("OPTIONS", ""); err != nil {
+		return err
+	}
+
+	resp, err := send("DESCRIBE", "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == 401 {
+		if p.Username == "" {
+			return fmt.Errorf("%w: camera requires credentials", ErrAuthFailed)
+		}
+		authorization, err := p.authorization("DESCRIBE", uri, resp.Header.Values("WWW-Authenticate"))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrAuthFailed, err)
+		}
+		if resp, err = send("DESCRIBE", authorization); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case resp.StatusCode == 200:
+		return nil
+	case resp.StatusCode == 401 || resp.StatusCode == 403:
+		return fmt.Errorf("%w: %s", ErrAuthFailed, resp.Status)
+	case resp.StatusCode == 404 || resp.StatusCode == 454:
+		return fmt.Errorf("%w: %s", ErrStreamNotFound, resp.Status)
+	default:
+		return fmt.Errorf("unexpected RTSP response to DESCRIBE: %s", resp.Status)
+	}
+}
+
+// readRTSPResponse 读取状态行、头部并丢弃响应体
+func readRTSPResponse(reader *bufio.Reader) (*rtspResponse, error) {
+	tp := textproto.NewReader(reader)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "RTSP/") {
+		return nil, fmt.Errorf("invalid status line %q", line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid status line %q", line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if length, _ := strconv.Atoi(header.Get("Content-Length")); length > 0 {
+		if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+			return nil, err
+		}
+	}
+	return &rtspResponse{
+		StatusCode: code,
+		Status:     strings.Join(parts[1:], " "),
+		Header:     header,
+	}, nil
+}
+
+// authorization 根据 WWW-Authenticate 生成认证头，优先使用 Digest
+func (p *RTSPProbe) authorization(method, uri string, challenges []string) (string, error) {
+	for _, challenge := range challenges {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+		if strings.EqualFold(scheme, "Digest") {
+			return p.digestAuthorization(method, uri, parseAuthParams(params))
+		}
+	}
+	for _, challenge := range challenges {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(challenge)), "basic") {
+			credentials := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+			return "Basic " + credentials, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported authentication challenge %q", strings.Join(challenges, ", "))
+}
+
+// digestAuthorization 计算 RFC 2617 Digest 认证头，支持 qop=auth 以及不带 qop 的旧式摄像头
+func (p *RTSPProbe) digestAuthorization(method, uri string, params map[string]string) (string, error) {
+	realm, nonce := params["realm"], params["nonce"]
+	if nonce == "" {
+		return "", fmt.Errorf("digest challenge without nonce")
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+
+	ha1 := md5Hex(p.Username + ":" + realm + ":" + p.Password)
+	ha2 := md5Hex(method + ":" + uri)
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, p.Username),
+		fmt.Sprintf(`realm="%s"`, realm),
+		fmt.Sprintf(`nonce="%s"`, nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+
+	qop := ""
+	for _, option := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(option) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		cnonce, nc := hex.EncodeToString(buf), p.nonceCount(nonce)
+		response := md5Hex(strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":"))
+		fields = append(fields, fmt.Sprintf(`response="%s"`, response),
+			"qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	} else {
+		fields = append(fields, fmt.Sprintf(`response="%s"`, md5Hex(ha1+":"+nonce+":"+ha2)))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// nonceCount 返回本次请求的 nc 值，同一个 nonce 每次使用加一，摄像头更换 nonce 后从 1 重新开始
+func (p *RTSPProbe) nonceCount(nonce string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if nonce != p.nonce {
+		p.nonce, p.nc = nonce, 0
+	}
+	p.nc++
+	return fmt.Sprintf("%08x", p.nc)
+}
+
+// parseAuthParams 解析 key="value", key=value 形式的认证参数
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, s = rest[1:], ""
+			} else {
+				value, s = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRTSPServer 在本地端口上模拟摄像头，使用 Digest 认证保护 DESCRIBE
+type fakeRTSPServer struct {
+	listener net.Listener
+	username string
+	password string
+	qop      bool   // challenge 中是否带 qop="auth"
+	stream   string // 存在的码流路径
+	methods  chan string
+}
+
+func newFakeRTSPServer(t *testing.T, username, password string, qop bool) *fakeRTSPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRTSPServer{
+		listener: listener,
+		username: username,
+		password: password,
+		qop:      qop,
+		stream:   "/live",
+		methods:  make(chan string, 100),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeRTSPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRTSPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		msg, _, err := readRTSPMessage(reader)
+		if err != nil {
+			return
+		}
+		method, rest, _ := strings.Cut(msg.line, " ")
+		uri, _, _ := strings.Cut(rest, " ")
+		authorization := msg.get("Authorization")
+		if authorization != "" {
+			method += " (auth)"
+		}
+		s.methods <- method
+
+		status, header := "200 OK", ""
+		switch {
+		case strings.HasPrefix(msg.line, "OPTIONS "):
+			header = "Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN\r\n"
+		case s.username != "" && !s.checkDigest(msg.line, uri, authorization):
+			status = "401 Unauthorized"
+			challenge := `Digest realm="fake", nonce="0123456789abcdef"`
+			if s.qop {
+				challenge += `, qop="auth"`
+			}
+			header = "WWW-Authenticate: " + challenge + "\r\n"
+		case !strings.HasSuffix(uri, s.stream):
+			status = "404 Stream Not Found"
+		}
+		fmt.Fprintf(conn, "RTSP/1.0 %s\r\nCSeq: %s\r\n%sContent-Length: 0\r\n\r\n", status, msg.get("CSeq"), header)
+	}
+}
+
+// checkDigest 按 RFC 2617 校验 Digest 认证头
+func (s *fakeRTSPServer) checkDigest(line, uri, authorization string) bool {
+	scheme, rest, _ := strings.Cut(authorization, " ")
+	if scheme != "Digest" {
+		return false
+	}
+	params := parseAuthParams(rest)
+	if params["username"] != s.username || params["uri"] != uri {
+		return false
+	}
+	method, _, _ := strings.Cut(line, " ")
+	ha1 := md5Hex(s.username + ":fake:" + s.password)
+	ha2 := md5Hex(method + ":" + uri)
+	want := md5Hex(ha1 + ":0123456789abcdef:" + ha2)
+	if params["qop"] == "auth" {
+		want = md5Hex(strings.Join([]string{ha1, "0123456789abcdef", params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	}
+	return params["response"] == want
+}
+
+func (s *fakeRTSPServer) camera(username, password, stream string) CameraConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return CameraConfig{IP: host, Port: port, Username: username, Password: password, Stream: stream}
+}
+
+// receivedMethods 返回服务端按顺序收到的请求
+func (s *fakeRTSPServer) receivedMethods() []string {
+	var methods []string
+	for {
+		select {
+		case method := <-s.methods:
+			methods = append(methods, method)
+		default:
+			return methods
+		}
+	}
+}
+
+func TestRTSPProbe(t *testing.T) {
+	tests := []struct {
+		name        string
+		server      [2]string // 服务端要求的用户名和密码，用户名为空时不需要认证
+		qop         bool
+		credentials [2]string
+		stream      string
+		wantErr     error
+		wantMethods string
+	}{
+		{
+			name:        "no auth required",
+			stream:      "/live",
+			wantMethods: "OPTIONS, DESCRIBE",
+		},
+		{
+			name:        "digest challenge and retry",
+			server:      [2]string{"admin", "secret"},
+			credentials: [2]string{"admin", "secret"},
+			stream:      "live",
+			wantMethods: "OPTIONS, DESCRIBE, DESCRIBE (auth)",
+		},
+		{
+			name:        "digest with qop",
+			server:      [2]string{"admin", "secret"},
+			qop:         true,
+			credentials: [2]string{"admin", "secret"},
+			stream:      "/live",
+			wantMethods: "OPTIONS, DESCRIBE, DESCRIBE (auth)",
+		},
+		{
+			name:        "wrong password",
+			server:      [2]string{"admin", "secret"},
+			credentials: [2]string{"admin", "wrong"},
+			stream:      "/live",
+			wantErr:     ErrAuthFailed,
+			wantMethods: "OPTIONS, DESCRIBE, DESCRIBE (auth)",
+		},
+		{
+			name:        "missing credentials",
+			server:      [2]string{"admin", "secret"},
+			stream:      "/live",
+			wantErr:     ErrAuthFailed,
+			wantMethods: "OPTIONS, DESCRIBE",
+		},
+		{
+			name:        "stream not found",
+			stream:      "/other",
+			wantErr:     ErrStreamNotFound,
+			wantMethods: "OPTIONS, DESCRIBE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeRTSPServer(t, tt.server[0], tt.server[1], tt.qop)
+			probe := NewRTSPProbe(server.camera(tt.credentials[0], tt.credentials[1], tt.stream))
+			err := probe.Probe()
+			if !errors.Is(err, tt.wantErr) || (err != nil && tt.wantErr == nil) {
+				t.Fatalf("Probe() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := strings.Join(server.receivedMethods(), ", "); got != tt.wantMethods {
+				t.Errorf("requests = %s, want %s", got, tt.wantMethods)
+			}
+		})
+	}
+}
+
+func TestDigestNonceCount(t *testing.T) {
+	probe := NewRTSPProbe(CameraConfig{IP: "127.0.0.1", Port: "554", Username: "admin", Password: "secret"})
+	challenge := func(nonce string) []string {
+		return []string{`Digest realm="fake", nonce="` + nonce + `", qop="auth"`}
+	}
+	// 同一个 nonce 的 nc 依次递增，nonce 改变后重新从 1 开始
+	steps := []struct{ nonce, nc string }{
+		{"aaaa", "00000001"},
+		{"aaaa", "00000002"},
+		{"aaaa", "00000003"},
+		{"bbbb", "00000001"},
+		{"bbbb", "00000002"},
+	}
+	for i, step := range steps {
+		authorization, err := probe.authorization("DESCRIBE", "rtsp://127.0.0.1:554/live", challenge(step.nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rest, _ := strings.Cut(authorization, " ")
+		if got := parseAuthParams(rest)["nc"]; got != step.nc {
+			t.Errorf("step %d: nc = %s, want %s", i, got, step.nc)
+		}
+	}
+}
+
+func TestRTSPProbeUnreachable(t *testing.T) {
+	// 关闭监听后该端口没有服务
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	probe := NewRTSPProbe(CameraConfig{IP: host, Port: port, Stream: "/live"})
+	probe.Timeout = time.Second
+	err = probe.Probe()
+	if !errors.Is(err, ErrCameraUnreachable) {
+		t.Fatalf("Probe() error = %v, want %v", err, ErrCameraUnreachable)
+	}
+	if got := probeErrorType(err); got != "unreachable" {
+		t.Errorf("probeErrorType() = %q, want unreachable", got)
+	}
+}
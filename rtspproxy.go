@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RTSPAuthProxy 本地 RTSP 认证代理，ffmpeg 连接代理时不需要在命令行中携带用户名和密码
+// 代理将请求原样转发给摄像头，摄像头要求认证时使用配置中的凭据添加 Authorization 头后重发请求
+// ffmpeg 使用 -rtsp_transport tcp，媒体数据也通过同一个连接传输
+//
+// 代理只监听 127.0.0.1，并要求客户端使用每次启动 ffmpeg 时随机生成的用户名和密码进行 Basic 认证，
+// 避免本机的其他进程直接借用摄像头凭据。随机凭据出现在 ffmpeg 的命令行中，
+// 能够查看进程列表的本机用户在这个 ffmpeg 运行期间仍然可以通过代理访问码流，但无法得到摄像头的密码
+type RTSPAuthProxy struct {
+	auth        *RTSPProbe // 摄像头地址和凭据，复用探测器的认证逻辑
+	logger      *slog.Logger
+	mu          sync.Mutex
+	listener    net.Listener
+	credentials string // 客户端认证使用的 "用户名:密码"，每次 Start 时重新生成，由 mu 保护
+}
+
+// NewRTSPAuthProxy 创建认证代理，调用 Start 后才开始监听
+func NewRTSPAuthProxy(camera CameraConfig, logger *slog.Logger) *RTSPAuthProxy {
+	return &RTSPAuthProxy{
+		auth:   NewRTSPProbe(camera),
+		logger: logger,
+	}
+}
+
+// Start 在 127.0.0.1 的随机端口上监听（已启动时继续使用原端口），生成新的代理凭据，
+// 返回带有代理凭据（不是摄像头凭据）的 RTSP 地址，之前的凭据不能再建立新的连接
+func (p *RTSPAuthProxy) Start() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		p.listener = listener
+		go p.serve(listener)
+	}
+	p.credentials = hex.EncodeToString(buf[:8]) + ":" + hex.EncodeToString(buf[8:])
+	return "rtsp://" + p.credentials + "@" + p.listener.Addr().String() + p.auth.Path, nil
+}
+
+// clientAuthorization 返回客户端使用当前代理凭据时的 Basic 认证头
+func (p *RTSPAuthProxy) clientAuthorization() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(p.credentials))
+}
+
+// Close 停止监听，已建立的连接在 ffmpeg 退出时关闭
+func (p *RTSPAuthProxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	err := p.listener.Close()
+	p.listener = nil
+	return err
+}
+
+func (p *RTSPAuthProxy) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn, listener.Addr().String())
+	}
+}
+
+// rtspProxySession 代理中的一个 ffmpeg 连接
+type rtspProxySession struct {
+	proxy       *RTSPAuthProxy
+	client      net.Conn
+	server      net.Conn
+	localPrefix string // ffmpeg 请求中使用的代理地址，转发时替换为摄像头地址
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+	pending     map[string]*rtspMessage // 等待响应的请求，按 CSeq 索引
+	retried     map[string]bool         // 已经添加认证头重发过的请求
+	challenges  []string                // 摄像头最近一次返回的 WWW-Authenticate
+}
+
+func (p *RTSPAuthProxy) handle(client net.Conn, localAddr string) {
+	defer client.Close()
+	// 客户端通过认证之后才连接摄像头
+	reader := bufio.NewReader(client)
+	first, err := p.authenticate(client, reader)
+	if err != nil {
+		p.logger.Debug("RTSP auth proxy rejected client", "error", err)
+		return
+	}
+	server, err := net.DialTimeout("tcp", p.auth.Address, p.auth.Timeout)
+	if err != nil {
+		p.logger.Warn("RTSP auth proxy failed to connect to camera", "error", err)
+		return
+	}
+	defer server.Close()
+
+	s := &rtspProxySession{
+		proxy:       p,
+		client:      client,
+		server:      server,
+		localPrefix: "rtsp://" + localAddr,
+		pending:     make(map[string]*rtspMessage),
+		retried:     make(map[string]bool),
+	}
+	go func() {
+		if err := s.forwardResponses(); err != nil && err != io.EOF {
+			p.logger.Debug("RTSP auth proxy connection closed", "error", err)
+		}
+		// 摄像头断开连接时同时断开 ffmpeg 的连接
+		client.Close()
+	}()
+	err = s.forwardRequest(first)
+	if err == nil {
+		err = s.forwardRequests(reader)
+	}
+	if err != nil && err != io.EOF {
+		p.logger.Debug("RTSP auth proxy connection closed", "error", err)
+	}
+}
+
+// authenticate 要求客户端使用当前的代理凭据进行 Basic 认证，返回第一个通过认证的请求
+// 认证之前的请求由代理直接返回 401，收到 interleaved 数据时断开连接
+func (p *RTSPAuthProxy) authenticate(client net.Conn, reader *bufio.Reader) (*rtspMessage, error) {
+	for {
+		msg, frame, err := readRTSPMessage(reader)
+		if err != nil {
+			return nil, err
+		}
+		if frame != nil {
+			return nil, errors.New("interleaved data before authentication")
+		}
+		if subtle.ConstantTimeCompare([]byte(msg.get("Authorization")), []byte(p.clientAuthorization())) == 1 {
+			return msg, nil
+		}
+		_, err = fmt.Fprintf(client, "RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\nWWW-Authenticate: Basic realm=\"autoUpdateCam\"\r\nContent-Length: 0\r\n\r\n",
+			msg.get("CSeq"))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// forwardRequests 将 ffmpeg 的请求和数据转发给摄像头
+func (s *rtspProxySession) forwardRequests(reader *bufio.Reader) error {
+	for {
+		msg, frame, err := readRTSPMessage(reader)
+		if err != nil {
+			return err
+		}
+		if frame != nil {
+			if err := s.writeServer(frame); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.forwardRequest(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardRequest 转发一个请求，摄像头要求过认证时直接添加认证头
+func (s *rtspProxySession) forwardRequest(msg *rtspMessage) error {
+	// 客户端的认证头只用于代理，不转发给摄像头
+	msg.del("Authorization")
+
+	// 请求中的代理地址替换为摄像头地址，Digest 认证的 uri 需要与摄像头看到的一致
+	parts := strings.SplitN(msg.line, " ", 3)
+	if len(parts) == 3 && strings.HasPrefix(parts[1], s.localPrefix) {
+		parts[1] = "rtsp://" + s.proxy.auth.Address + strings.TrimPrefix(parts[1], s.localPrefix)
+		msg.line = strings.Join(parts, " ")
+	}
+
+	s.mu.Lock()
+	cseq := msg.get("CSeq")
+	s.pending[cseq] = msg
+	challenges := s.challenges
+	s.mu.Unlock()
+
+	out := msg
+	if len(challenges) > 0 {
+		var err error
+		if out, err = s.authorize(msg, challenges); err != nil {
+			return err
+		}
+	}
+	return s.writeServer(out.bytes())
+}
+
+// forwardResponses 将摄像头的响应和数据转发给 ffmpeg，需要认证的请求由代理重发
+func (s *rtspProxySession) forwardResponses() error {
+	reader := bufio.NewReader(s.server)
+	for {
+		msg, frame, err := readRTSPMessage(reader)
+		if err != nil {
+			return err
+		}
+		if frame != nil {
+			if _, err := s.client.Write(frame); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(msg.line, "RTSP/") {
+			cseq := msg.get("CSeq")
+			s.mu.Lock()
+			request := s.pending[cseq]
+			delete(s.pending, cseq)
+			retry := request != nil && !s.retried[cseq] && strings.HasPrefix(msg.line, "RTSP/1.0 401") &&
+				s.proxy.auth.Username != ""
+			if retry {
+				s.retried[cseq] = true
+				s.pending[cseq] = request
+				s.challenges = msg.values("WWW-Authenticate")
+			}
+			challenges := s.challenges
+			s.mu.Unlock()
+
+			if retry {
+				authorized, err := s.authorize(request, challenges)
+				if err != nil {
+					return err
+				}
+				if err := s.writeServer(authorized.bytes()); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if _, err := s.client.Write(msg.bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// authorize 返回添加了认证头的请求副本
+func (s *rtspProxySession) authorize(request *rtspMessage, challenges []string) (*rtspMessage, error) {
+	method, rest, _ := strings.Cut(request.line, " ")
+	uri, _, _ := strings.Cut(rest, " ")
+	authorization, err := s.proxy.auth.authorization(method, uri, challenges)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	authorized := request.clone()
+	authorized.set("Authorization", authorization)
+	return authorized, nil
+}
+
+func (s *rtspProxySession) writeServer(data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.server.Write(data)
+	return err
+}
+
+// rtspMessage RTSP 请求或响应，保留原始的首行和头部顺序
+type rtspMessage struct {
+	line   string
+	header []string // "Key: value" 形式的头部行
+	body   []byte
+}
+
+// readRTSPMessage 读取一个 RTSP 消息，以 '$' 开头的 interleaved 数据帧原样返回在 frame 中
+func readRTSPMessage(reader *bufio.Reader) (msg *rtspMessage, frame []byte, err error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first[0] == '$' {
+		// $ + 通道号 + 2 字节长度 + 数据
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			return nil, nil, err
+		}
+		frame = make([]byte, 4+int(binary.BigEndian.Uint16(head[2:])))
+		copy(frame, head)
+		if _, err := io.ReadFull(reader, frame[4:]); err != nil {
+			return nil, nil, err
+		}
+		return nil, frame, nil
+	}
+
+	tp := textproto.NewReader(reader)
+	msg = &rtspMessage{}
+	if msg.line, err = tp.ReadLine(); err != nil {
+		return nil, nil, err
+	}
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return nil, nil, err
+		}
+		if line == "" {
+			break
+		}
+		msg.header = append(msg.header, line)
+	}
+	if length, _ := strconv.Atoi(msg.get("Content-Length")); length > 0 {
+		msg.body = make([]byte, length)
+		if _, err := io.ReadFull(reader, msg.body); err != nil {
+			return nil, nil, err
+		}
+	}
+	return msg, nil, nil
+}
+
+// values 返回指定头部的所有取值，名称不区分大小写
+func (m *rtspMessage) values(key string) []string {
+	var values []string
+	for _, line := range m.header {
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(strings.TrimSpace(name), key) {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+func (m *rtspMessage) get(key string) string {
+	if values := m.values(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// set 替换指定头部，不存在时添加
+func (m *rtspMessage) set(key, value string) {
+	m.del(key)
+	m.header = append(m.header, key+": "+value)
+}
+
+// del 删除指定头部
+func (m *rtspMessage) del(key string) {
+	header := m.header[:0:0]
+	for _, line := range m.header {
+		name, _, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), key) {
+			header = append(header, line)
+		}
+	}
+	m.header = header
+}
+
+func (m *rtspMessage) clone() *rtspMessage {
+	return &rtspMessage{
+		line:   m.line,
+		header: append([]string(nil), m.header...),
+		body:   m.body,
+	}
+}
+
+func (m *rtspMessage) bytes() []byte {
+	var b strings.Builder
+	b.WriteString(m.line + "\r\n")
+	for _, line := range m.header {
+		b.WriteString(line + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(m.body)
+	return []byte(b.String())
+}
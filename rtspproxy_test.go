@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRTSPAuthProxy(t *testing.T) {
+	server := newFakeRTSPServer(t, "admin", "secret", true)
+	proxy := NewRTSPAuthProxy(server.camera("admin", "secret", "/live"), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	input, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	u, err := url.Parse(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := u.User.Password()
+	if u.Hostname() != "127.0.0.1" || u.User.Username() == "" || password == "" || password == "secret" {
+		t.Fatalf("Start() = %s, want random credentials on 127.0.0.1", input)
+	}
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.String()))
+	wrong := "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":wrong"))
+	uri := "rtsp://" + u.Host + u.Path
+
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	cseq := 0
+	request := func(method, authorization string) string {
+		t.Helper()
+		cseq++
+		header := ""
+		if authorization != "" {
+			header = "Authorization: " + authorization + "\r\n"
+		}
+		fmt.Fprintf(conn, "%s %s RTSP/1.0\r\nCSeq: %d\r\n%s\r\n", method, uri, cseq, header)
+		msg, _, err := readRTSPMessage(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.line
+	}
+
+	// 没有代理凭据或凭据错误时由代理返回 401，不会连接摄像头
+	if got := request("OPTIONS", ""); got != "RTSP/1.0 401 Unauthorized" {
+		t.Errorf("OPTIONS without credentials = %s", got)
+	}
+	if got := request("OPTIONS", wrong); got != "RTSP/1.0 401 Unauthorized" {
+		t.Errorf("OPTIONS with wrong credentials = %s", got)
+	}
+	if got := server.receivedMethods(); len(got) > 0 {
+		t.Errorf("camera received %v before client authenticated", got)
+	}
+
+	// 通过认证后代理去掉客户端的认证头，并在摄像头要求时使用摄像头凭据重发
+	if got := request("OPTIONS", basic); got != "RTSP/1.0 200 OK" {
+		t.Errorf("OPTIONS = %s", got)
+	}
+	if got := request("DESCRIBE", basic); got != "RTSP/1.0 200 OK" {
+		t.Errorf("DESCRIBE = %s", got)
+	}
+	if got := strings.Join(server.receivedMethods(), ", "); got != "OPTIONS, DESCRIBE, DESCRIBE (auth)" {
+		t.Errorf("camera received %s", got)
+	}
+
+	// 重新启动 ffmpeg 时生成新的凭据，之前的凭据不能再建立连接
+	restarted, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, _ := url.Parse(restarted)
+	if u2.Host != u.Host || u2.User.String() == u.User.String() {
+		t.Fatalf("second Start() = %s, want new credentials on %s", restarted, u.Host)
+	}
+	conn.Close()
+	if conn, err = net.Dial("tcp", u.Host); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader = bufio.NewReader(conn)
+	if got := request("OPTIONS", basic); got != "RTSP/1.0 401 Unauthorized" {
+		t.Errorf("OPTIONS with previous credentials = %s", got)
+	}
+	if got := request("OPTIONS", "Basic "+base64.StdEncoding.EncodeToString([]byte(u2.User.String()))); got != "RTSP/1.0 200 OK" {
+		t.Errorf("OPTIONS with new credentials = %s", got)
+	}
+}
+
+func TestRTSPAuthProxyRejectsDataBeforeAuth(t *testing.T) {
+	server := newFakeRTSPServer(t, "", "", false)
+	proxy := NewRTSPAuthProxy(server.camera("", "", "/live"), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	input, err := proxy.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	u, _ := url.Parse(input)
+
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{'$', 0, 0, 2, 'h', 'i'})
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() error = %v, want connection closed", err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleConfig 录制时间段配置
+// 未配置 windows 时使用 StartHour:StartMinute - EndHour:EndMinute 作为每天的录制时间段
+type ScheduleConfig struct {
+	StartHour   int            `json:"start_hour"`
+	StartMinute int            `json:"start_minute"`
+	EndHour     int            `json:"end_hour"`
+	EndMinute   int            `json:"end_minute"`
+	Windows     []WindowConfig `json:"windows"`
+	Timezone    string         `json:"timezone"` // IANA 时区名称，如 "Asia/Shanghai"，为空时使用系统时区
+}
+
+// WindowConfig 单个录制窗口
+type WindowConfig struct {
+	Days  string `json:"days"`  // 星期，如 "mon-fri"、"sat,sun"，为空表示每天
+	Start string `json:"start"` // 开始时间，如 "22:00"
+	End   string `json:"end"`   // 结束时间，不晚于开始时间时表示跨越午夜到第二天
+}
+
+// window 解析后的录制窗口，时间以当天零点起的分钟数表示
+type window struct {
+	days  [7]bool // 按 time.Weekday 索引，窗口属于开始时间所在的那一天
+	start int
+	end   int
+}
+
+// Schedule 录制计划，由一个或多个按星期重复的窗口组成
+// 窗口按所在时区的本地时间计算，夏令时切换当天仍然在相同的本地时间开始和结束
+type Schedule struct {
+	windows  []window
+	location *time.Location
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// NewSchedule 解析录制时间段配置
+func NewSchedule(config *ScheduleConfig) (*Schedule, error) {
+	windows := config.Windows
+	if len(windows) == 0 {
+		windows = []WindowConfig{{
+			Start: fmt.Sprintf("%02d:%02d", config.StartHour, config.StartMinute),
+			End:   fmt.Sprintf("%02d:%02d", config.EndHour, config.EndMinute),
+		}}
+	}
+
+	schedule := &Schedule{location: time.Local}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+		}
+		schedule.location = location
+	}
+	for i, wc := range windows {
+		w, err := parseWindow(wc)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %v", i+1, err)
+		}
+		schedule.windows = append(schedule.windows, w)
+	}
+	return schedule, nil
+}
+
+// ParseScheduleWindows 解析 "mon-fri 08:00-18:00; sat,sun 22:00-06:00" 形式的窗口列表
+func ParseScheduleWindows(spec string) ([]WindowConfig, error) {
+	var windows []WindowConfig
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Fields(item)
+		var wc WindowConfig
+		switch len(fields) {
+		case 1:
+			wc.Days = ""
+		case 2:
+			wc.Days = fields[0]
+		default:
+			return nil, fmt.Errorf("invalid schedule window %q", item)
+		}
+		times := strings.SplitN(fields[len(fields)-1], "-", 2)
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid schedule window %q: expected HH:MM-HH:MM", item)
+		}
+		wc.Start, wc.End = times[0], times[1]
+		windows = append(windows, wc)
+	}
+	return windows, nil
+}
+
+func parseWindow(wc WindowConfig) (window, error) {
+	var w window
+	days, err := parseDays(wc.Days)
+	if err != nil {
+		return w, err
+	}
+	w.days = days
+	if w.start, err = parseClock(wc.Start); err != nil {
+		return w, fmt.Errorf("invalid start time %q: %v", wc.Start, err)
+	}
+	if w.end, err = parseClock(wc.End); err != nil {
+		return w, fmt.Errorf("invalid end time %q: %v", wc.End, err)
+	}
+	if w.start >= 24*60 {
+		return w, fmt.Errorf("invalid start time %q", wc.Start)
+	}
+	return w, nil
+}
+
+// parseClock 解析 HH:MM，结束时间允许 24:00
+func parseClock(s string) (int, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("expected HH:MM")
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid hour")
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid minute")
+	}
+	if hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("out of range")
+	}
+	return hour*60 + minute, nil
+}
+
+// parseDays 解析星期列表，支持 "mon-fri"、"sat,sun"、"fri-mon" 以及 "*"
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdayNames[strings.TrimSpace(bounds[0])]
+		if !ok {
+			return days, fmt.Errorf("invalid weekday %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdayNames[strings.TrimSpace(bounds[1])]; !ok {
+				return days, fmt.Errorf("invalid weekday %q", bounds[1])
+			}
+		}
+		// 支持跨周末的范围，如 fri-mon
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// occurrence 返回窗口在指定日期的开始和结束时间，未在该日启用时返回 false
+func (w window) occurrence(year int, month time.Month, day int, loc *time.Location) (time.Time, time.Time, bool) {
+	start := time.Date(year, month, day, 0, w.start, 0, 0, loc)
+	if !w.days[start.Weekday()] {
+		return time.Time{}, time.Time{}, false
+	}
+	endDay := day
+	if w.end <= w.start {
+		endDay++ // 跨越午夜
+	}
+	end := time.Date(year, month, endDay, 0, w.end, 0, 0, loc)
+	return start, end, true
+}
+
+// Active 返回包含时间 t 的录制窗口，有多个时返回结束最晚的一个
+func (s *Schedule) Active(t time.Time) (time.Time, time.Time, bool) {
+	t = t.In(s.location)
+	var start, end time.Time
+	found := false
+	// 前一天开始的窗口可能跨越午夜覆盖到今天
+	for offset := -1; offset <= 0; offset++ {
+		day := t.AddDate(0, 0, offset)
+		for _, w := range s.windows {
+			ws, we, ok := w.occurrence(day.Year(), day.Month(), day.Day(), t.Location())
+			if !ok || t.Before(ws) || !t.Before(we) {
+				continue
+			}
+			if !found || we.After(end) {
+				start, end, found = ws, we, true
+			}
+		}
+	}
+	return start, end, found
+}
+
+// Next 返回时间 t 之后最早开始的录制窗口
+func (s *Schedule) Next(t time.Time) (time.Time, time.Time, bool) {
+	t = t.In(s.location)
+	var start, end time.Time
+	found := false
+	for offset := 0; offset <= 7; offset++ {
+		day := t.AddDate(0, 0, offset)
+		for _, w := range s.windows {
+			ws, we, ok := w.occurrence(day.Year(), day.Month(), day.Day(), t.Location())
+			if !ok || !ws.After(t) {
+				continue
+			}
+			if !found || ws.Before(start) {
+				start, end, found = ws, we, true
+			}
+		}
+		if found {
+			break
+		}
+	}
+	return start, end, found
+}
+
+// Location 返回录制计划使用的时区
+func (s *Schedule) Location() *time.Location {
+	return s.location
+}
+
+// String 返回便于日志输出的窗口描述
+func (s *Schedule) String() string {
+	var parts []string
+	for _, w := range s.windows {
+		var days []string
+		all := true
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if w.days[d] {
+				days = append(days, strings.ToLower(d.String()[:3]))
+			} else {
+				all = false
+			}
+		}
+		dayStr := strings.Join(days, ",")
+		if all {
+			dayStr = "daily"
+		}
+		parts = append(parts, fmt.Sprintf("%s %02d:%02d-%02d:%02d", dayStr, w.start/60, w.start%60, w.end/60, w.end%60))
+	}
+	return strings.Join(parts, "; ") + " (" + s.location.String() + ")"
+}
+
+// Clock 时间来源，测试时可以注入模拟时钟
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock 使用系统时间的时钟
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// 调度器动作
+const (
+	ScheduleNone  = iota // 保持当前状态
+	ScheduleStart        // 进入新的窗口，需要开始录制
+	ScheduleStop         // 离开窗口，需要停止录制
+)
+
+// Scheduler 每次调用时都根据当前时间重新计算所在窗口或下一个窗口，
+// 因此程序在窗口结束后启动、系统时间跳变或时区切换时都能得到正确的结果
+type Scheduler struct {
+	schedule    *Schedule
+	activeStart time.Time // 当前所在窗口的开始时间，不在窗口内时为零值
+}
+
+// NewScheduler 创建调度器
+func NewScheduler(schedule *Schedule) *Scheduler {
+	return &Scheduler{schedule: schedule}
+}
+
+// Tick 根据时间 now 返回需要执行的动作，以及当前所在（或下一个）窗口的开始和结束时间
+// 每个窗口只返回一次 ScheduleStart，窗口内手动停止录制后不会被重新开始；
+// 相邻的窗口之间不会返回 ScheduleStop
+func (s *Scheduler) Tick(now time.Time) (int, time.Time, time.Time) {
+	if start, end, ok := s.schedule.Active(now); ok {
+		if start.Equal(s.activeStart) {
+			return ScheduleNone, start, end
+		}
+		s.activeStart = start
+		return ScheduleStart, start, end
+	}
+
+	action := ScheduleNone
+	if !s.activeStart.IsZero() {
+		s.activeStart = time.Time{}
+		action = ScheduleStop
+	}
+	start, end, _ := s.schedule.Next(now)
+	return action, start, end
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock 模拟时钟，After 立即将时间向前推进 d
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// 2026-10-16 是星期五
+func at(day, hour, minute int) time.Time {
+	return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+}
+
+func mustSchedule(t *testing.T, spec string) *Schedule {
+	t.Helper()
+	windows, err := ParseScheduleWindows(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := NewSchedule(&ScheduleConfig{Windows: windows, Timezone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+func TestParseScheduleWindows(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []WindowConfig
+		wantErr bool
+	}{
+		{spec: "08:00-18:00", want: []WindowConfig{{Start: "08:00", End: "18:00"}}},
+		{
+			spec: "mon-fri 08:00-18:00; sat,sun 22:00-06:00",
+			want: []WindowConfig{
+				{Days: "mon-fri", Start: "08:00", End: "18:00"},
+				{Days: "sat,sun", Start: "22:00", End: "06:00"},
+			},
+		},
+		{spec: " ; 08:00-09:00 ;", want: []WindowConfig{{Start: "08:00", End: "09:00"}}},
+		{spec: "mon 08:00", wantErr: true},
+		{spec: "mon fri 08:00-09:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseScheduleWindows(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScheduleWindows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseScheduleWindows() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"25:00-26:00", "08:00-24:30", "funday 08:00-09:00", "08:60-09:00"} {
+		windows, err := ParseScheduleWindows(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewSchedule(&ScheduleConfig{Windows: windows}); err == nil {
+			t.Errorf("NewSchedule(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestScheduleActiveNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		now        time.Time
+		active     bool
+		start, end time.Time // active 为 true 时是所在窗口，否则是下一个窗口
+	}{
+		// 窗口边界：开始时间包含在窗口内，结束时间不包含
+		{"before start", "08:00-18:00", at(16, 7, 59), false, at(16, 8, 0), at(16, 18, 0)},
+		{"at start", "08:00-18:00", at(16, 8, 0), true, at(16, 8, 0), at(16, 18, 0)},
+		{"before end", "08:00-18:00", at(16, 17, 59), true, at(16, 8, 0), at(16, 18, 0)},
+		{"at end", "08:00-18:00", at(16, 18, 0), false, at(17, 8, 0), at(17, 18, 0)},
+
+		// 开始时间晚于结束时间的窗口跨越午夜
+		{"overnight evening", "22:00-06:00", at(16, 23, 0), true, at(16, 22, 0), at(17, 6, 0)},
+		{"overnight after midnight", "22:00-06:00", at(17, 0, 30), true, at(16, 22, 0), at(17, 6, 0)},
+		{"overnight at end", "22:00-06:00", at(17, 6, 0), false, at(17, 22, 0), at(18, 6, 0)},
+		{"overnight before start", "22:00-06:00", at(16, 21, 59), false, at(16, 22, 0), at(17, 6, 0)},
+		// 跨越午夜的窗口属于开始时间所在的那一天
+		{"overnight weekday continues", "fri 22:00-06:00", at(17, 3, 0), true, at(16, 22, 0), at(17, 6, 0)},
+		{"overnight weekday next week", "fri 22:00-06:00", at(17, 22, 0), false, at(23, 22, 0), at(24, 6, 0)},
+		// 开始和结束时间相同表示整整 24 小时
+		{"full day", "06:00-06:00", at(16, 5, 0), true, at(15, 6, 0), at(16, 6, 0)},
+
+		// 每天多个窗口
+		{"between windows", "08:00-12:00; 13:00-17:00", at(16, 12, 30), false, at(16, 13, 0), at(16, 17, 0)},
+		{"second window", "08:00-12:00; 13:00-17:00", at(16, 14, 0), true, at(16, 13, 0), at(16, 17, 0)},
+		{"after last window", "08:00-12:00; 13:00-17:00", at(16, 17, 0), false, at(17, 8, 0), at(17, 12, 0)},
+		{"weekend only", "sat,sun 10:00-12:00; mon-fri 20:00-21:00", at(16, 21, 0), false, at(17, 10, 0), at(17, 12, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := mustSchedule(t, tt.spec)
+			start, end, active := schedule.Active(tt.now)
+			if active != tt.active {
+				t.Fatalf("Active(%v) = %v, want %v", tt.now, active, tt.active)
+			}
+			if !active {
+				var ok bool
+				if start, end, ok = schedule.Next(tt.now); !ok {
+					t.Fatalf("Next(%v) found no window", tt.now)
+				}
+			}
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("window = %v - %v, want %v - %v", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestSchedulerTick(t *testing.T) {
+	type step struct {
+		advance time.Duration // 执行 Tick 之前推进时钟的时间
+		action  int
+		start   time.Time
+	}
+	tests := []struct {
+		name  string
+		spec  string
+		begin time.Time
+		steps []step
+	}{
+		{
+			name:  "single window",
+			spec:  "08:00-18:00",
+			begin: at(16, 7, 0),
+			steps: []step{
+				{0, ScheduleNone, at(16, 8, 0)},
+				{time.Hour, ScheduleStart, at(16, 8, 0)},
+				{4 * time.Hour, ScheduleNone, at(16, 8, 0)},
+				{6 * time.Hour, ScheduleStop, at(17, 8, 0)},
+				{time.Minute, ScheduleNone, at(17, 8, 0)},
+			},
+		},
+		{
+			// 程序在窗口中途重启时立即开始录制，之后不会重复开始
+			name:  "restart mid-window",
+			spec:  "08:00-18:00",
+			begin: at(16, 12, 0),
+			steps: []step{
+				{0, ScheduleStart, at(16, 8, 0)},
+				{time.Second, ScheduleNone, at(16, 8, 0)},
+				{6 * time.Hour, ScheduleStop, at(17, 8, 0)},
+			},
+		},
+		{
+			name:  "overnight window",
+			spec:  "22:00-06:00",
+			begin: at(16, 21, 0),
+			steps: []step{
+				{0, ScheduleNone, at(16, 22, 0)},
+				{time.Hour, ScheduleStart, at(16, 22, 0)},
+				{150 * time.Minute, ScheduleNone, at(16, 22, 0)},
+				{330 * time.Minute, ScheduleStop, at(17, 22, 0)},
+			},
+		},
+		{
+			// 相邻的窗口之间不停止录制
+			name:  "adjacent windows",
+			spec:  "08:00-12:00; 12:00-14:00",
+			begin: at(16, 11, 59),
+			steps: []step{
+				{0, ScheduleStart, at(16, 8, 0)},
+				{time.Minute, ScheduleStart, at(16, 12, 0)},
+				{2 * time.Hour, ScheduleStop, at(17, 8, 0)},
+			},
+		},
+		{
+			name:  "multiple windows per day",
+			spec:  "08:00-12:00; 13:00-17:00",
+			begin: at(16, 11, 0),
+			steps: []step{
+				{0, ScheduleStart, at(16, 8, 0)},
+				{time.Hour, ScheduleStop, at(16, 13, 0)},
+				{time.Hour, ScheduleStart, at(16, 13, 0)},
+				{4 * time.Hour, ScheduleStop, at(17, 8, 0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: tt.begin}
+			scheduler := NewScheduler(mustSchedule(t, tt.spec))
+			for i, s := range tt.steps {
+				if s.advance > 0 {
+					<-clock.After(s.advance)
+				}
+				action, start, _ := scheduler.Tick(clock.Now())
+				if action != s.action || !start.Equal(s.start) {
+					t.Errorf("step %d at %v: Tick() = %d, %v, want %d, %v", i, clock.Now(), action, start, s.action, s.start)
+				}
+			}
+		})
+	}
+}
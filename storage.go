@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Uploader 存储后端接口，路径均为相对于后端根目录的 "/" 分隔路径
+type Uploader interface {
+	// Upload 上传本地文件到 destPath
+	Upload(srcPath, destPath string) error
+	// Exists 检查远端文件是否存在
+	Exists(destPath string) (bool, error)
+	// Delete 删除远端文件
+	Delete(destPath string) error
+	// List 列出远端目录下的文件名
+	List(dir string) ([]string, error)
+}
+
+// newUploaderBackend 根据 upload.backend 创建存储后端
+func newUploaderBackend(config *UploadConfig) (Uploader, error) {
+	switch strings.ToLower(config.Backend) {
+	case "", "alist":
+		return NewAlistUploader(config), nil
+	case "local":
+		if config.LocalPath == "" {
+			return nil, fmt.Errorf("upload.local_path is required for local backend")
+		}
+		return NewLocalUploader(config.LocalPath), nil
+	case "webdav":
+		if config.WebDAVURL == "" {
+			return nil, fmt.Errorf("upload.webdav_url is required for webdav backend")
+		}
+		return NewWebDAVUploader(config), nil
+	case "s3":
+		if config.S3Endpoint == "" || config.S3Bucket == "" {
+			return nil, fmt.Errorf("upload.s3_endpoint and upload.s3_bucket are required for s3 backend")
+		}
+		return NewS3Uploader(config), nil
+	default:
+		return nil, fmt.Errorf("unknown upload backend: %s", config.Backend)
+	}
+}
+
+// cleanRemotePath 统一远端路径分隔符并去掉开头的斜杠
+func cleanRemotePath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(p, "\\", "/")), "/")
+}
+
+// LocalUploader 本地目录存储后端，可用于挂载的 NFS/SMB 目录
+type LocalUploader struct {
+	root string
+}
+
+// NewLocalUploader 创建本地目录存储后端
+func NewLocalUploader(root string) *LocalUploader {
+	return &LocalUploader{root: root}
+}
+
+func (l *LocalUploader) fullPath(p string) string {
+	return filepath.Join(l.root, filepath.FromSlash(cleanRemotePath(p)))
+}
+
+// Upload 复制文件到目标目录，先写临时文件再重命名，避免留下不完整的文件
+func (l *LocalUploader) Upload(srcPath, destPath string) error {
+	target := l.fullPath(destPath)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer srcFile.Close()
+
+	tmpPath := target + ".part"
+	dstFile, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy file content: %v", err)
+	}
+	if err := dstFile.Sync(); err != nil {
+		dstFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync file: %v", err)
+	}
+	if err := dstFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close file: %v", err)
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename file: %v", err)
+	}
+	return nil
+}
+
+// Exists 检查目标文件是否存在
+func (l *LocalUploader) Exists(destPath string) (bool, error) {
+	_, err := os.Stat(l.fullPath(destPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete 删除目标文件
+func (l *LocalUploader) Delete(destPath string) error {
+	if err := os.Remove(l.fullPath(destPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove file: %v", err)
+	}
+	return nil
+}
+
+// List 列出目标目录下的文件名
+func (l *LocalUploader) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(l.fullPath(dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// WebDAVUploader WebDAV 存储后端
+type WebDAVUploader struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAVUploader 创建 WebDAV 存储后端
+func NewWebDAVUploader(config *UploadConfig) *WebDAVUploader {
+	return &WebDAVUploader{
+		baseURL:  strings.TrimSuffix(config.WebDAVURL, "/"),
+		username: config.WebDAVUser,
+		password: config.WebDAVPass,
+		client:   newHTTPClient(config, "webdav"),
+	}
+}
+
+// url 返回远端路径对应的 URL，每一级路径单独转义
+func (w *WebDAVUploader) url(p string) string {
+	var segments []string
+	for _, segment := range strings.Split(cleanRemotePath(p), "/") {
+		if segment != "" {
+			segments = append(segments, url.PathEscape(segment))
+		}
+	}
+	return w.baseURL + "/" + strings.Join(segments, "/")
+}
+
+func (w *WebDAVUploader) do(method, p string, body io.Reader, contentLength int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, w.url(p), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if body != nil {
+		req.ContentLength = contentLength
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %v", method, err)
+	}
+	return resp, nil
+}
+
+// mkdirAll 逐级创建远端目录，已存在的目录会返回 405
+func (w *WebDAVUploader) mkdirAll(dir string) error {
+	current := ""
+	for _, segment := range strings.Split(cleanRemotePath(dir), "/") {
+		if segment == "" || segment == "." {
+			continue
+		}
+		current = path.Join(current, segment)
+		resp, err := w.do("MKCOL", current+"/", nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusCreated, http.StatusMethodNotAllowed, http.StatusOK, http.StatusMovedPermanently:
+		default:
+			return fmt.Errorf("failed to create directory %s: status %d", current, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// Upload 通过 PUT 上传文件
+func (w *WebDAVUploader) Upload(srcPath, destPath string) error {
+	if err := w.mkdirAll(path.Dir(cleanRemotePath(destPath))); err != nil {
+		return err
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	resp, err := w.do("PUT", destPath, srcFile, srcInfo.Size(), map[string]string{
+		"Content-Type": "application/octet-stream",
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+}
+
+// Exists 通过 HEAD 检查远端文件是否存在
+func (w *WebDAVUploader) Exists(destPath string) (bool, error) {
+	resp, err := w.do("HEAD", destPath, nil, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+// Delete 删除远端文件
+func (w *WebDAVUploader) Delete(destPath string) error {
+	resp, err := w.do("DELETE", destPath, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("delete failed with status %d", resp.StatusCode)
+	}
+}
+
+// List 通过 PROPFIND 列出远端目录下的文件名
+func (w *WebDAVUploader) List(dir string) ([]string, error) {
+	resp, err := w.do("PROPFIND", strings.TrimSuffix(dir, "/")+"/", nil, 0, map[string]string{
+		"Depth": "1",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("list failed with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Responses []struct {
+			Href string `xml:"href"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	self := path.Base("/" + cleanRemotePath(dir))
+	var names []string
+	for i, item := range result.Responses {
+		href, err := url.PathUnescape(item.Href)
+		if err != nil {
+			href = item.Href
+		}
+		name := path.Base(strings.TrimSuffix(href, "/"))
+		// 第一个条目是目录本身
+		if i == 0 && name == self {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -3,37 +3,183 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// FileUploader 文件上传器
+// 上传模式
+const (
+	UploadModeSegments = "segments" // 录制期间逐个上传片段
+	UploadModeMerged   = "merged"   // 录制结束后合并片段，只上传合并文件
+	UploadModeBoth     = "both"     // 同时上传片段和合并文件
+)
+
+// FileUploader 文件上传器，根据配置将文件上传到对应的存储后端
+// 配置和存储后端可以在运行时整体替换，正在进行的上传继续使用替换前的后端完成
 type FileUploader struct {
+	state atomic.Pointer[uploaderState]
+}
+
+// uploaderState 一份上传配置及对应的存储后端
+type uploaderState struct {
+	config  *UploadConfig
+	backend Uploader
+}
+
+// NewFileUploader 创建新的文件上传器
+func NewFileUploader(config *UploadConfig) (*FileUploader, error) {
+	state, err := newUploaderState(config)
+	if err != nil {
+		return nil, err
+	}
+	u := &FileUploader{}
+	u.state.Store(state)
+	return u, nil
+}
+
+// Config 返回当前的上传配置，调用方不能修改返回的配置
+func (u *FileUploader) Config() *UploadConfig {
+	return u.state.Load().config
+}
+
+// Update 校验新的上传配置，成功后替换配置和存储后端，失败时保留原配置
+func (u *FileUploader) Update(config *UploadConfig) error {
+	state, err := newUploaderState(config)
+	if err != nil {
+		return err
+	}
+	u.state.Store(state)
+	return nil
+}
+
+func newUploaderState(config *UploadConfig) (*uploaderState, error) {
+	switch config.Mode {
+	case "":
+		config.Mode = UploadModeSegments
+	case UploadModeSegments, UploadModeMerged, UploadModeBoth:
+	default:
+		return nil, fmt.Errorf("unknown upload mode: %s", config.Mode)
+	}
+	if config.MergeInterval < 0 || config.MergeSegments < 0 {
+		return nil, fmt.Errorf("upload.merge_interval and upload.merge_segments must not be negative")
+	}
+	backend, err := newUploaderBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	return &uploaderState{
+		config:  config,
+		backend: backend,
+	}, nil
+}
+
+// UploadFile 上传单个文件，destPath 为相对于存储后端根目录的路径，keepLocal 为 false 时上传成功后删除本地文件
+func (u *FileUploader) UploadFile(srcPath, destPath string, keepLocal bool) error {
+	if err := u.state.Load().backend.Upload(srcPath, destPath); err != nil {
+		return err
+	}
+	if keepLocal {
+		return nil
+	}
+
+	// 上传成功后，等待一小段时间确保文件句柄完全释放
+	time.Sleep(100 * time.Millisecond)
+
+	// 删除源文件
+	maxRetries := 3
+	for i := 0; i < maxRetries; i++ {
+		if err := os.Remove(srcPath); err != nil {
+			if i < maxRetries-1 {
+				slog.Debug("Failed to remove source file, retrying", "file", srcPath, "attempt", i+1, "error", err)
+				time.Sleep(500 * time.Millisecond)
+				continue
+			}
+			slog.Warn("Failed to remove source file", "file", srcPath, "attempts", maxRetries, "error", err)
+		} else {
+			slog.Debug("Removed source file", "file", srcPath)
+			break
+		}
+	}
+
+	return nil
+}
+
+// AlistUploader Alist 存储后端
+type AlistUploader struct {
 	config *UploadConfig
+	client *http.Client
+	mu     sync.Mutex // 保护 token
 	token  string
 }
 
-// NewFileUploader 创建新的文件上传器
-func NewFileUploader(config *UploadConfig) *FileUploader {
-	return &FileUploader{
+// alistAPIError Alist 接口返回的业务错误
+type alistAPIError struct {
+	Code    int
+	Message string
+}
+
+func (e *alistAPIError) Error() string {
+	return fmt.Sprintf("alist error %d: %s", e.Code, e.Message)
+}
+
+// NewAlistUploader 创建 Alist 存储后端
+func NewAlistUploader(config *UploadConfig) *AlistUploader {
+	return &AlistUploader{
 		config: config,
+		client: newHTTPClient(config, "alist"),
+	}
+}
+
+// remotePath 返回 Alist 上的完整路径，确保路径以斜杠开头
+func (a *AlistUploader) remotePath(destPath string) string {
+	fullPath := path.Join(a.config.AlistPath, strings.ReplaceAll(destPath, "\\", "/"))
+	return "/" + strings.TrimPrefix(fullPath, "/")
+}
+
+// getToken 返回当前 token，没有时先登录
+func (a *AlistUploader) getToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == "" {
+		if err := a.getAlistToken(); err != nil {
+			metrics.Inc(metricAlistLoginFailures)
+			return "", fmt.Errorf("failed to get Alist token: %v", err)
+		}
 	}
+	return a.token, nil
+}
+
+// clearToken 清除过期的 token
+func (a *AlistUploader) clearToken() {
+	a.mu.Lock()
+	a.token = ""
+	a.mu.Unlock()
 }
 
-// getAlistToken 获取Alist token
-func (u *FileUploader) getAlistToken() error {
+// getAlistToken 获取Alist token，调用方需持有锁
+func (a *AlistUploader) getAlistToken() error {
 	// 准备登录请求数据
 	loginData := map[string]string{
-		"username": u.config.AlistUser,
-		"password": u.config.AlistPass,
+		"username": a.config.AlistUser,
+		"password": a.config.AlistPass,
 	}
 
 	jsonData, err := json.Marshal(loginData)
@@ -42,7 +188,7 @@ func (u *FileUploader) getAlistToken() error {
 	}
 
 	// 创建登录请求
-	req, err := http.NewRequest("POST", u.config.AlistURL+"/api/auth/login", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", a.config.AlistURL+"/api/auth/login", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create login request: %v", err)
 	}
@@ -51,8 +197,7 @@ func (u *FileUploader) getAlistToken() error {
 	req.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send login request: %v", err)
 	}
@@ -83,11 +228,69 @@ func (u *FileUploader) getAlistToken() error {
 	}
 
 	// 保存token
-	u.token = result.Data.Token
-	log.Printf("Successfully obtained Alist token")
+	a.token = result.Data.Token
+	slog.Info("Obtained Alist token")
 	return nil
 }
 
+// apiRequest 调用 Alist JSON 接口，token 过期时重新登录并重试一次
+func (a *AlistUploader) apiRequest(apiPath string, payload interface{}, data interface{}) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := a.getToken()
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest("POST", a.config.AlistURL+apiPath, bytes.NewReader(jsonData))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %v", err)
+		}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("request %s failed with status %d: %s", apiPath, resp.StatusCode, string(bodyBytes))
+		}
+
+		var result struct {
+			Code    int             `json:"code"`
+			Message string          `json:"message"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(bodyBytes, &result); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+		if result.Code == 401 && attempt == 0 {
+			// token 过期，重新登录后重试
+			a.clearToken()
+			continue
+		}
+		if result.Code != 200 {
+			return &alistAPIError{Code: result.Code, Message: result.Message}
+		}
+		if data != nil && len(result.Data) > 0 {
+			if err := json.Unmarshal(result.Data, data); err != nil {
+				return fmt.Errorf("failed to decode response data: %v", err)
+			}
+		}
+		return nil
+	}
+}
+
 // compressToZip 将文件压缩为zip格式，如果压缩效果不理想则返回原文件
 func (u *FileUploader) compressToZip(inputFile string) (string, bool, error) {
 	// 获取原始文件大小
@@ -158,13 +361,12 @@ func (u *FileUploader) compressToZip(inputFile string) (string, bool, error) {
 
 	// 计算压缩率
 	compressionRatio := float64(compressedSize) / float64(originalSize)
-	fmt.Printf("Original size: %.2f MB\n", float64(originalSize)/1024/1024)
-	fmt.Printf("Compressed size: %.2f MB\n", float64(compressedSize)/1024/1024)
-	fmt.Printf("Compression ratio: %.2f%%\n", compressionRatio*100)
+	slog.Debug("Compressed file", "file", inputFile, "original_bytes", originalSize,
+		"compressed_bytes", compressedSize, "ratio", fmt.Sprintf("%.2f%%", compressionRatio*100))
 
 	// 如果压缩后文件更大或者压缩率大于95%，则使用原文件
 	if compressionRatio >= 0.95 {
-		fmt.Println("Compression not effective, using original file")
+		slog.Debug("Compression not effective, using original file", "file", inputFile)
 		os.Remove(zipFile) // 删除无效的压缩文件
 		return inputFile, false, nil
 	}
@@ -172,137 +374,531 @@ func (u *FileUploader) compressToZip(inputFile string) (string, bool, error) {
 	return zipFile, true, nil
 }
 
-// UploadFile 上传单个文件到Alist
-func (u *FileUploader) UploadFile(srcPath, destPath string, date string) (map[string]interface{}, error) {
-	// 如果没有token，先获取token
-	if u.token == "" {
-		if err := u.getAlistToken(); err != nil {
-			return nil, fmt.Errorf("failed to get Alist token: %v", err)
-		}
+// buildMultipartEnvelope 生成包裹文件内容的 multipart 头部和尾部
+func buildMultipartEnvelope(fileName, filePath string) ([]byte, []byte, string, error) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+
+	// 文件字段头部
+	if _, err := writer.CreateFormFile("file", fileName); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to create form file: %v", err)
 	}
+	head := append([]byte(nil), buf.Bytes()...)
+	buf.Reset()
 
-	// 打开要上传的文件
-	srcFile, err := os.Open(srcPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
+	// 文件内容之后的路径字段和结束分隔符
+	if err := writer.WriteField("path", filePath); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to write path field: %v", err)
 	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to close writer: %v", err)
+	}
+	tail := append([]byte(nil), buf.Bytes()...)
 
-	// 创建multipart form
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	return head, tail, writer.FormDataContentType(), nil
+}
 
-	// 添加文件
-	part, err := writer.CreateFormFile("file", filepath.Base(srcPath))
+// Upload 上传单个文件到Alist
+func (a *AlistUploader) Upload(srcPath, destPath string) error {
+	for attempt := 0; ; attempt++ {
+		code, err := a.upload(srcPath, destPath)
+		if code == 401 && attempt == 0 {
+			// 如果是token过期，重新获取token并重试
+			a.clearToken()
+			continue
+		}
+		return err
+	}
+}
+
+// upload 执行一次上传请求，返回 Alist 的响应码
+func (a *AlistUploader) upload(srcPath, destPath string) (int, error) {
+	token, err := a.getToken()
 	if err != nil {
-		srcFile.Close()
-		return nil, fmt.Errorf("failed to create form file: %v", err)
+		return 0, err
 	}
 
-	if _, err := io.Copy(part, srcFile); err != nil {
-		srcFile.Close()
-		return nil, fmt.Errorf("failed to copy file content: %v", err)
+	// 打开要上传的文件
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %v", err)
 	}
+	defer srcFile.Close()
 
-	// 关闭源文件
-	srcFile.Close()
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file info: %v", err)
+	}
 
 	// 添加路径参数，确保路径以斜杠开头
-	filePath := filepath.Join(u.config.AlistPath, date, filepath.Base(srcPath))
-	filePath = "/" + strings.TrimPrefix(strings.ReplaceAll(filePath, "\\", "/"), "/")
+	filePath := a.remotePath(destPath)
 
 	// 将路径中的斜杠替换为 %2F
 	encodedPath := strings.ReplaceAll(filePath, "/", "%2F")
 
-	if err := writer.WriteField("path", filePath); err != nil {
-		return nil, fmt.Errorf("failed to write path field: %v", err)
-	}
-
-	// 关闭writer
-	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close writer: %v", err)
+	// 预先生成 multipart 的头部和尾部，文件内容直接从磁盘流式发送，避免整个文件读入内存
+	head, tail, contentType, err := buildMultipartEnvelope(filepath.Base(srcPath), filePath)
+	if err != nil {
+		return 0, err
 	}
+	body := io.MultiReader(bytes.NewReader(head), srcFile, bytes.NewReader(tail))
 
 	// 创建请求
-	req, err := http.NewRequest("PUT", u.config.AlistURL+"/api/fs/form", body)
+	req, err := http.NewRequest("PUT", a.config.AlistURL+"/api/fs/form", body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return 0, fmt.Errorf("failed to create request: %v", err)
 	}
+	req.ContentLength = int64(len(head)) + srcInfo.Size() + int64(len(tail))
 
 	// 设置请求头
-	req.Header.Set("Authorization", u.token)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Referer", u.config.AlistURL+u.config.AlistPath)
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Referer", a.config.AlistURL+a.config.AlistPath)
 	req.Header.Set("file-path", encodedPath)
 
-	// 打印请求头信息
-	fmt.Println("\nRequest Headers:")
-	fmt.Printf("Authorization: %s\n", u.token)
-	fmt.Printf("Content-Type: %s\n", writer.FormDataContentType())
-	fmt.Printf("Referer: %s\n", u.config.AlistURL+u.config.AlistPath)
-	fmt.Printf("file-path: %s\n", encodedPath)
-	fmt.Printf("Request URL: %s\n", req.URL.String())
-	fmt.Printf("Request Method: %s\n", req.Method)
-	fmt.Printf("Upload Path: %s\n\n", filePath)
+	slog.Debug("Sending Alist upload request", "method", req.Method, "url", req.URL.String(),
+		"content_type", contentType, "content_length", req.ContentLength, "path", filePath)
 
 	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return 0, fmt.Errorf("failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// 读取响应内容
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return 0, fmt.Errorf("failed to read response body: %v", err)
 	}
 
 	// 检查响应
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return 0, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	// 解析响应
-	var result map[string]interface{}
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
 	if err := json.Unmarshal(bodyBytes, &result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+		return 0, fmt.Errorf("failed to decode response: %v", err)
 	}
 
 	// 检查响应状态
-	if code, ok := result["code"].(float64); !ok || code != 200 {
-		// 如果是token过期，尝试重新获取token并重试
-		if code == 401 {
-			u.token = "" // 清除旧token
-			if err := u.getAlistToken(); err != nil {
-				return nil, fmt.Errorf("failed to refresh token: %v", err)
-			}
-			// 重试上传
-			return u.UploadFile(srcPath, destPath, date)
+	if result.Code != 200 {
+		return result.Code, fmt.Errorf("upload failed: %s", result.Message)
+	}
+	return result.Code, nil
+}
+
+// Exists 检查 Alist 上是否存在指定文件
+func (a *AlistUploader) Exists(destPath string) (bool, error) {
+	err := a.apiRequest("/api/fs/get", map[string]string{"path": a.remotePath(destPath)}, nil)
+	var apiErr *alistAPIError
+	if errors.As(err, &apiErr) && strings.Contains(strings.ToLower(apiErr.Message), "not found") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete 删除 Alist 上的指定文件
+func (a *AlistUploader) Delete(destPath string) error {
+	filePath := a.remotePath(destPath)
+	payload := map[string]interface{}{
+		"dir":   path.Dir(filePath),
+		"names": []string{path.Base(filePath)},
+	}
+	return a.apiRequest("/api/fs/remove", payload, nil)
+}
+
+// List 列出 Alist 目录下的文件名
+func (a *AlistUploader) List(dir string) ([]string, error) {
+	payload := map[string]interface{}{
+		"path":     a.remotePath(dir),
+		"page":     1,
+		"per_page": 0,
+		"refresh":  true,
+	}
+	var data struct {
+		Content []struct {
+			Name string `json:"name"`
+		} `json:"content"`
+	}
+	if err := a.apiRequest("/api/fs/list", payload, &data); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(data.Content))
+	for _, item := range data.Content {
+		names = append(names, item.Name)
+	}
+	return names, nil
+}
+
+const (
+	s3MultipartThreshold = 64 << 20 // 超过该大小的文件使用分片上传
+	s3PartSize           = 16 << 20 // 分片大小，S3 要求除最后一片外不小于 5MB
+	s3UnsignedPayload    = "UNSIGNED-PAYLOAD"
+)
+
+// S3Uploader S3 兼容对象存储后端（AWS S3、MinIO 等），使用 path-style 地址和 SigV4 签名
+type S3Uploader struct {
+	endpoint  string
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	now       func() time.Time
+}
+
+// NewS3Uploader 创建 S3 兼容存储后端
+func NewS3Uploader(config *UploadConfig) *S3Uploader {
+	region := config.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Uploader{
+		endpoint:  strings.TrimSuffix(config.S3Endpoint, "/"),
+		region:    region,
+		bucket:    config.S3Bucket,
+		prefix:    cleanRemotePath(config.S3Prefix),
+		accessKey: config.S3AccessKey,
+		secretKey: config.S3SecretKey,
+		client:    newHTTPClient(config, "s3"),
+		now:       time.Now,
+	}
+}
+
+// objectKey 返回带前缀的对象名
+func (s *S3Uploader) objectKey(destPath string) string {
+	key := cleanRemotePath(destPath)
+	if s.prefix != "" && s.prefix != "." {
+		key = s.prefix + "/" + key
+	}
+	return key
+}
+
+// awsURIEncode 按 SigV4 规则对字符串进行 URI 编码
+func awsURIEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
 		}
-		return nil, fmt.Errorf("upload failed: %v", result["message"])
 	}
+	return buf.String()
+}
 
-	// 上传成功后，等待一小段时间确保文件句柄完全释放
-	time.Sleep(100 * time.Millisecond)
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
 
-	// 删除源文件
-	maxRetries := 3
-	for i := 0; i < maxRetries; i++ {
-		if err := os.Remove(srcPath); err != nil {
-			if i < maxRetries-1 {
-				log.Printf("Attempt %d: Failed to remove source file %s: %v, retrying...", i+1, srcPath, err)
-				time.Sleep(500 * time.Millisecond)
-				continue
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newRequest 创建并签名 S3 请求，body 为空时按空负载签名，否则使用 UNSIGNED-PAYLOAD
+func (s *S3Uploader) newRequest(method, key string, query url.Values, body io.Reader, contentLength int64) (*http.Request, error) {
+	canonicalURI := "/" + awsURIEncode(s.bucket, true)
+	if key != "" {
+		canonicalURI += "/" + awsURIEncode(key, false)
+	}
+
+	// 查询参数按名称排序并编码
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	queryParts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			queryParts = append(queryParts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	canonicalQuery := strings.Join(queryParts, "&")
+
+	rawURL := s.endpoint + canonicalURI
+	if canonicalQuery != "" {
+		rawURL += "?" + canonicalQuery
+	}
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if body != nil {
+		req.ContentLength = contentLength
+	}
+
+	payloadHash := sha256Hex(nil)
+	if body != nil {
+		payloadHash = s3UnsignedPayload
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// 生成 SigV4 签名
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		method, canonicalURI, canonicalQuery, canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+	return req, nil
+}
+
+// do 发送请求并在状态码不符合预期时返回包含响应内容的错误
+func (s *S3Uploader) do(req *http.Request, expected ...int) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %v", req.Method, err)
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, string(bodyBytes))
+}
+
+// Upload 上传文件，大文件使用分片上传
+func (s *S3Uploader) Upload(srcPath, destPath string) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %v", err)
+	}
+
+	key := s.objectKey(destPath)
+	if srcInfo.Size() > s3MultipartThreshold {
+		return s.uploadMultipart(srcFile, srcInfo.Size(), key)
+	}
+
+	req, err := s.newRequest("PUT", key, nil, srcFile, srcInfo.Size())
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// uploadMultipart 分片上传文件，失败时中止本次上传以释放已上传的分片
+func (s *S3Uploader) uploadMultipart(file *os.File, size int64, key string) error {
+	req, err := s.newRequest("POST", key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var initResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initResult)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode multipart upload response: %v", err)
+	}
+	uploadID := initResult.UploadID
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+
+	abort := func(cause error) error {
+		if req, err := s.newRequest("DELETE", key, url.Values{"uploadId": {uploadID}}, nil, 0); err == nil {
+			if resp, err := s.do(req, http.StatusNoContent, http.StatusOK); err == nil {
+				resp.Body.Close()
 			}
-			log.Printf("Warning: failed to remove source file %s after %d attempts: %v", srcPath, maxRetries, err)
-		} else {
-			log.Printf("Successfully removed source file: %s", srcPath)
-			break
 		}
+		return cause
+	}
+
+	for offset, partNumber := int64(0), 1; offset < size; offset, partNumber = offset+s3PartSize, partNumber+1 {
+		partSize := int64(s3PartSize)
+		if size-offset < partSize {
+			partSize = size - offset
+		}
+		query := url.Values{
+			"partNumber": {fmt.Sprintf("%d", partNumber)},
+			"uploadId":   {uploadID},
+		}
+		req, err := s.newRequest("PUT", key, query, io.NewSectionReader(file, offset, partSize), partSize)
+		if err != nil {
+			return abort(err)
+		}
+		resp, err := s.do(req, http.StatusOK)
+		if err != nil {
+			return abort(fmt.Errorf("failed to upload part %d: %v", partNumber, err))
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return abort(fmt.Errorf("failed to marshal complete request: %v", err))
+	}
+	req, err = s.newRequest("POST", key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return abort(err)
+	}
+	resp, err = s.do(req, http.StatusOK)
+	if err != nil {
+		return abort(err)
 	}
+	defer resp.Body.Close()
 
-	return result, nil
+	// CompleteMultipartUpload 即使返回 200 也可能在响应体中包含错误
+	var completeResult struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&completeResult); err != nil {
+		return abort(fmt.Errorf("failed to decode complete response: %v", err))
+	}
+	if completeResult.XMLName.Local == "Error" {
+		return abort(fmt.Errorf("complete multipart upload failed: %s: %s", completeResult.Code, completeResult.Message))
+	}
+	return nil
+}
+
+// Exists 检查对象是否存在
+func (s *S3Uploader) Exists(destPath string) (bool, error) {
+	req, err := s.newRequest("HEAD", s.objectKey(destPath), nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send HEAD request: %v", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+// Delete 删除对象
+func (s *S3Uploader) Delete(destPath string) error {
+	req, err := s.newRequest("DELETE", s.objectKey(destPath), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List 列出目录（前缀）下的对象名
+func (s *S3Uploader) List(dir string) ([]string, error) {
+	prefix := s.objectKey(dir)
+	if prefix != "" && prefix != "." {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	} else {
+		prefix = ""
+	}
+
+	var names []string
+	continuationToken := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+			"delimiter": {"/"},
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		req, err := s.newRequest("GET", "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode list response: %v", err)
+		}
+
+		for _, item := range result.Contents {
+			names = append(names, strings.TrimPrefix(item.Key, prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
 }
 
 //// UploadMergedFiles 上传合并后的文件
@@ -362,11 +958,12 @@ func (u *FileUploader) UploadFile(srcPath, destPath string, date string) (map[st
 
 // CleanupOldFiles 清理旧文件
 func (u *FileUploader) CleanupOldFiles(outputDir string) error {
-	if u.config.MaxFileAge <= 0 {
+	config := u.Config()
+	if config.MaxFileAge <= 0 {
 		return nil
 	}
 
-	files, err := filepath.Glob(filepath.Join(outputDir, u.config.FilePattern))
+	files, err := filepath.Glob(filepath.Join(outputDir, config.FilePattern))
 	if err != nil {
 		return fmt.Errorf("failed to list files: %v", err)
 	}
@@ -375,16 +972,16 @@ func (u *FileUploader) CleanupOldFiles(outputDir string) error {
 	for _, file := range files {
 		fileInfo, err := os.Stat(file)
 		if err != nil {
-			log.Printf("Warning: failed to get file info for %s: %v", file, err)
+			slog.Warn("Failed to get file info", "file", file, "error", err)
 			continue
 		}
 
 		age := now.Sub(fileInfo.ModTime())
-		if age > time.Duration(u.config.MaxFileAge)*24*time.Hour {
+		if age > time.Duration(config.MaxFileAge)*24*time.Hour {
 			if err := os.Remove(file); err != nil {
-				log.Printf("Warning: failed to remove old file %s: %v", file, err)
+				slog.Warn("Failed to remove old file", "file", file, "error", err)
 			} else {
-				log.Printf("Removed old file: %s", file)
+				slog.Info("Removed old file", "file", file)
 			}
 		}
 	}
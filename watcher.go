@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentWatchInterval 检查已完成片段的间隔
+const segmentWatchInterval = 10 * time.Second
+
+// 片段命名方式
+const (
+	SegmentNamingTimestamp = "timestamp" // cam1_20261016_081500.mkv
+	SegmentNamingSequence  = "sequence"  // segment_000.mkv
+)
+
+// segmentTimeLayout 片段文件名中的时间格式
+const segmentTimeLayout = "20060102_150405"
+
+// segmentPattern 返回 ffmpeg segment 的输出文件名模板
+func (r *Recorder) segmentPattern() string {
+	if r.segmentNaming == SegmentNamingSequence {
+		return "segment_%03d.mkv"
+	}
+	return r.name + "_%Y%m%d_%H%M%S.mkv"
+}
+
+// isSegmentFile 判断文件名是否为 ffmpeg 输出的录制片段，两种命名方式的片段都会被识别
+func (r *Recorder) isSegmentFile(name string) bool {
+	if _, ok := r.segmentTimestamp(name); ok {
+		return true
+	}
+	_, ok := segmentSequence(name)
+	return ok
+}
+
+// segmentTimestamp 解析片段文件名中的开始时间，如 cam1_20261016_081500.mkv
+func (r *Recorder) segmentTimestamp(name string) (time.Time, bool) {
+	prefix := r.name + "_"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".mkv") {
+		return time.Time{}, false
+	}
+	value := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".mkv")
+	t, err := time.ParseInLocation(segmentTimeLayout, value, r.schedule.Load().Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// segmentSequence 解析 segment_000.mkv 形式的片段序号
+func segmentSequence(name string) (int, bool) {
+	if !strings.HasPrefix(name, "segment_") || !strings.HasSuffix(name, ".mkv") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "segment_"), ".mkv"))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// nextSequence 返回下一个未使用的片段序号，同时考虑本地片段和上传队列中（可能已删除）的片段，调用方需持有锁
+func (r *Recorder) nextSequence(dir string) int {
+	next := r.sequence
+	names := r.queue.Files(dir)
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+	}
+	for _, name := range names {
+		if n, ok := segmentSequence(filepath.Base(name)); ok && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
+// segmentLess 按文件名中的时间排序片段，序号命名的片段按序号排在时间命名的片段之前
+func (r *Recorder) segmentLess(a, b string) bool {
+	a, b = filepath.Base(a), filepath.Base(b)
+	ta, okA := r.segmentTimestamp(a)
+	tb, okB := r.segmentTimestamp(b)
+	switch {
+	case okA && okB:
+		return ta.Before(tb)
+	case okA != okB:
+		return okB
+	}
+	na, okA := segmentSequence(a)
+	nb, okB := segmentSequence(b)
+	if okA && okB {
+		return na < nb
+	}
+	return a < b
+}
+
+// watchSegments 录制期间定期检查已完成的片段并立即加入上传队列，直到 done 被关闭
+func (r *Recorder) watchSegments(done <-chan struct{}) {
+	ticker := time.NewTicker(segmentWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := r.enqueueCompletedSegments(false); err != nil {
+				r.logger.Warn("Failed to scan segments", "error", err)
+			}
+			r.checkOnline()
+			if r.uploader.Config().Mode != UploadModeSegments && r.rollingMerge() {
+				r.mergeCompletedGroups(false)
+			}
+		}
+	}
+}
+
+// enqueueCompletedSegments 将有效片段加入上传队列并返回加入的文件路径
+// 录制期间 all 为 false，最近修改的片段被视为 ffmpeg 正在写入，当下一个片段出现后才会被上传
+// merged 模式下片段只在合并后上传，both 模式下片段上传后保留到合并文件上传成功
+func (r *Recorder) enqueueCompletedSegments(all bool) ([]string, error) {
+	segments, err := r.completedSegments(all)
+	if err != nil || r.uploader.Config().Mode == UploadModeMerged {
+		return nil, err
+	}
+
+	r.enqueueSegments(segments, r.uploader.Config().Mode == UploadModeBoth)
+	return segments, nil
+}
+
+// enqueueSegments 将片段加入上传队列，keep 为 true 时上传后保留本地文件用于合并
+// 配置了 upload.keep_local 时所有片段上传后都保留在本地，由 max_file_age 清理
+func (r *Recorder) enqueueSegments(segments []string, keep bool) {
+	keep = keep || r.uploader.Config().KeepLocal
+	sessionDate := r.SessionDate()
+	for _, filePath := range segments {
+		task := UploadTask{
+			Camera:   r.name,
+			SrcPath:  filePath,
+			DestPath: path.Join(r.name, sessionDate, filepath.Base(filePath)),
+			Keep:     keep,
+		}
+		if r.queue.Enqueue(task) {
+			metrics.Inc(metricSegmentsProduced, "camera", r.name)
+		}
+	}
+}
+
+// completedSegments 返回已完成的有效片段的绝对路径，并删除无效（小于 1KB）的片段
+func (r *Recorder) completedSegments(all bool) ([]string, error) {
+	absOutputDir, err := filepath.Abs(r.outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %v", err)
+	}
+
+	files, err := os.ReadDir(absOutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %v", err)
+	}
+
+	type segmentInfo struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var segments []segmentInfo
+	latest := -1
+	for _, file := range files {
+		if !r.isSegmentFile(file.Name()) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segmentInfo{file.Name(), info.Size(), info.ModTime()})
+		if latest < 0 || info.ModTime().After(segments[latest].modTime) {
+			latest = len(segments) - 1
+		}
+	}
+
+	r.mu.Lock()
+	r.currentSegment = ""
+	if !all && latest >= 0 {
+		r.currentSegment = segments[latest].name
+	}
+	r.mu.Unlock()
+
+	var srcPaths []string
+	for i, segment := range segments {
+		if i == latest && !all {
+			continue // 正在写入
+		}
+		filePath := filepath.Join(absOutputDir, segment.name)
+		if segment.size < 1024 {
+			// 删除无效的分片文件
+			if err := os.Remove(filePath); err != nil {
+				r.logger.Warn("Failed to remove invalid segment file", "segment", segment.name, "error", err)
+			} else {
+				metrics.Inc(metricInvalidSegments, "camera", r.name)
+			}
+			continue
+		}
+		srcPaths = append(srcPaths, filePath)
+	}
+	sort.Slice(srcPaths, func(i, j int) bool {
+		return r.segmentLess(srcPaths[i], srcPaths[j])
+	})
+	return srcPaths, nil
+}